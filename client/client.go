@@ -2,15 +2,33 @@
 package client
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
-	log "github.com/sirupsen/logrus"
 	"io"
+	"log/slog"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// A Client makes Gemini and Titan requests.
+//
+// The zero value is a Client verifying server certificates against the system roots, logging to [slog.Default].
+type Client struct {
+	// TLSConfig is used to connect to servers. If nil, a configuration verifying the certificate of the server
+	// against the system roots is used. As Gemini servers commonly use self-signed certificates, it is usually
+	// necessary to set InsecureSkipVerify or a custom VerifyPeerCertificate function
+	TLSConfig *tls.Config
+	// Logger receives the log messages of the Client. If nil, [slog.Default] is used
+	Logger *slog.Logger
+	// DialTimeout is the maximum duration allowed for connecting to a server, including the TLS handshake.
+	// Zero means no timeout
+	DialTimeout time.Duration
+}
+
 // A Response represents a Gemini response
 type Response struct {
 	// Data contains the raw data returned from the server.
@@ -24,6 +42,17 @@ type Response struct {
 	StatusCode int
 }
 
+// A StreamResponse represents a Gemini response whose body is read directly from the connection
+type StreamResponse struct {
+	// MetaData contains the metadata of the response.
+	// If StatusCode == 20, it is the MIME type associated with the data
+	MetaData string
+	// StatusCode contains the status code returned by the server.
+	StatusCode int
+	// Body reads the body of the response. It must be closed once the response is no longer needed
+	Body io.ReadCloser
+}
+
 // Request sends a Gemini request to address
 // tlsConfig will be removed in a future update. Per the [tls.Client] documentation,
 //
@@ -32,45 +61,187 @@ type Response struct {
 //
 // TODO: Does not currently handle redirects.
 func Request(address string, tlsConfig *tls.Config) (*Response, error) {
-	parsedURL, err := url.Parse(address)
+	return (&Client{TLSConfig: tlsConfig}).Request(address)
+}
+
+// RequestStream sends a Gemini request to address in the same way as [Request], but returns as soon as the response
+// header has been read. The body can then be streamed from the Body of the [StreamResponse], which must be closed.
+func RequestStream(address string, tlsConfig *tls.Config) (*StreamResponse, error) {
+	return (&Client{TLSConfig: tlsConfig}).RequestStream(address)
+}
+
+// logger returns the [slog.Logger] of the client
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+
+	return c.Logger
+}
+
+// tlsConfig returns the TLS configuration used to connect to the host of u
+func (c *Client) tlsConfig(u *url.URL) *tls.Config {
+	if c.TLSConfig == nil {
+		return &tls.Config{ServerName: u.Hostname()}
+	}
+
+	return c.TLSConfig
+}
+
+// dial connects to the server of u and performs the TLS handshake, within DialTimeout
+func (c *Client) dial(ctx context.Context, u *url.URL) (*tls.Conn, error) {
+	if c.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DialTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	connInsecure, err := dialer.DialContext(ctx, "tcp", dialAddress(u))
 	if err != nil {
 		return nil, err
 	}
-	connInsecure, err := net.Dial("tcp", parsedURL.Host+":1965")
+
+	conn := tls.Client(connInsecure, c.tlsConfig(u))
+	err = conn.HandshakeContext(ctx)
 	if err != nil {
+		connInsecure.Close()
 		return nil, err
 	}
 
-	conn := tls.Client(connInsecure, tlsConfig)
+	return conn, nil
+}
+
+// Request sends a Gemini request to address, reading the entire response body into memory.
+//
+// TODO: Does not currently handle redirects.
+func (c *Client) Request(address string) (*Response, error) {
+	return c.RequestContext(context.Background(), address)
+}
 
-	defer func(conn *tls.Conn) {
-		err := conn.Close()
+// RequestContext sends a Gemini request to address in the same way as [Client.Request], giving up once ctx is done,
+// in which case the error of ctx is returned.
+func (c *Client) RequestContext(ctx context.Context, address string) (*Response, error) {
+	response, err := c.RequestStreamContext(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(body io.ReadCloser) {
+		err := body.Close()
 		if err != nil {
-			log.Errorf("%v", err)
+			c.logger().Error("Could not close connection", "url", address, "error", err)
 		}
-	}(conn)
+	}(response.Body)
 
-	_, err = conn.Write([]byte(address + "\r\n"))
+	data, err := io.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	responseData, err := io.ReadAll(conn)
+	return &Response{
+		StatusCode: response.StatusCode,
+		Data:       data,
+		MetaData:   response.MetaData,
+	}, nil
+}
+
+// RequestStream sends a Gemini request to address in the same way as [Client.Request], but returns as soon as the
+// response header has been read. The body can then be streamed from the Body of the [StreamResponse], which must be
+// closed.
+func (c *Client) RequestStream(address string) (*StreamResponse, error) {
+	return c.RequestStreamContext(context.Background(), address)
+}
+
+// RequestStreamContext sends a Gemini request to address in the same way as [Client.RequestStream]. ctx applies to
+// the whole request, including reading the body: once it is done, the connection is closed and reads return the
+// error of ctx.
+func (c *Client) RequestStreamContext(ctx context.Context, address string) (*StreamResponse, error) {
+	parsedURL, err := url.Parse(address)
 	if err != nil {
 		return nil, err
 	}
+	conn, err := c.dial(ctx, parsedURL)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	// Closing the underlying connection interrupts reads and writes blocked on the TLS connection
+	stop := context.AfterFunc(ctx, func() {
+		conn.NetConn().Close()
+	})
+	fail := func(err error) (*StreamResponse, error) {
+		stop()
+		conn.Close()
+		return nil, contextError(ctx, err)
+	}
 
-	header := strings.Split(string(responseData), "\r\n")[0]
-	headerParts := strings.Split(header, " ")
+	c.logger().Debug("Sending request", "url", address, "addr", conn.RemoteAddr().String())
+
+	_, err = conn.Write([]byte(address + "\r\n"))
+	if err != nil {
+		return fail(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+
+	headerParts := strings.Split(strings.TrimSuffix(header, "\r\n"), " ")
 	statusCode, err := strconv.Atoi(headerParts[0])
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 	metaData := strings.Join(headerParts[1:], " ")
+	c.logger().Debug("Received response", "url", address, "status", statusCode, "meta", metaData)
 
-	return &Response{
+	return &StreamResponse{
 		StatusCode: statusCode,
-		Data:       responseData[len(header)+2:],
 		MetaData:   metaData,
+		Body:       &streamBody{ctx: ctx, reader: reader, conn: conn, stop: stop},
 	}, nil
 }
+
+// streamBody is the body of a [StreamResponse] whose request was made with a context
+type streamBody struct {
+	ctx    context.Context
+	reader io.Reader
+	conn   net.Conn
+	stop   func() bool
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = contextError(b.ctx, err)
+	}
+
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	b.stop()
+
+	return b.conn.Close()
+}
+
+// contextError returns the error of ctx if it is done, since err is then caused by ctx, or err otherwise
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+// dialAddress returns the host:port to connect to for a URL, using the default Gemini port (1965) if none is specified
+func dialAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "1965"
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
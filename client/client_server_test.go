@@ -1,19 +1,55 @@
-package client
+package client_test
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
+	"github.com/nailuj29/gomini/client"
 	"github.com/nailuj29/gomini/gemtext"
 	"github.com/nailuj29/gomini/server"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestBasicRequestResponse(t *testing.T) {
+// listen starts s on a free local port, returning the address it is listening on.
+// The server is closed when the test finishes.
+func listen(t *testing.T, s *server.Server) string {
+	t.Helper()
+
 	cer, err := tls.LoadX509KeyPair("../examples/cert.pem", "../examples/key.pem")
 	if err != nil {
 		t.Fatal(err)
 	}
 	config := tls.Config{Certificates: []tls.Certificate{cer}, ClientAuth: tls.RequestClientCert}
 
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		s.Serve(l)
+	}()
+
+	t.Cleanup(func() {
+		err := s.Close()
+		if err != nil {
+			t.Errorf("Could not close server: %v", err)
+		}
+	})
+
+	return l.Addr().String()
+}
+
+func TestBasicRequestResponse(t *testing.T) {
 	s := server.New()
 
 	s.RegisterHandler("/", func(r server.Request) {
@@ -26,19 +62,10 @@ func TestBasicRequestResponse(t *testing.T) {
 		}
 	})
 
-	go func() {
-		s.ListenAndServe("localhost", &config)
-	}()
-
-	defer func(s *server.Server) {
-		err := s.Close()
-		if err != nil {
-			t.Errorf("Could not close server: %v", err)
-		}
-	}(s)
+	addr := listen(t, s)
 
 	clientConfig := tls.Config{InsecureSkipVerify: true}
-	response, err := Request("gemini://localhost/", &clientConfig)
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -67,12 +94,6 @@ func TestBasicRequestResponse(t *testing.T) {
 }
 
 func TestDynamicPathRequestResponse(t *testing.T) {
-	cer, err := tls.LoadX509KeyPair("../examples/cert.pem", "../examples/key.pem")
-	if err != nil {
-		t.Fatal(err)
-	}
-	config := tls.Config{Certificates: []tls.Certificate{cer}, ClientAuth: tls.RequestClientCert}
-
 	s := server.New()
 
 	s.RegisterHandler("/:param", func(r server.Request) {
@@ -82,19 +103,10 @@ func TestDynamicPathRequestResponse(t *testing.T) {
 		}
 	})
 
-	go func() {
-		s.ListenAndServe("localhost", &config)
-	}()
-
-	defer func(s *server.Server) {
-		err := s.Close()
-		if err != nil {
-			t.Errorf("Could not close server: %v", err)
-		}
-	}(s)
+	addr := listen(t, s)
 
 	clientConfig := tls.Config{InsecureSkipVerify: true}
-	response, err := Request("gemini://localhost/foo-bar", &clientConfig)
+	response, err := client.Request("gemini://"+addr+"/foo-bar", &clientConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -123,39 +135,29 @@ func TestDynamicPathRequestResponse(t *testing.T) {
 }
 
 func TestTitanRequestResponse(t *testing.T) {
-	cer, err := tls.LoadX509KeyPair("../examples/cert.pem", "../examples/key.pem")
-	if err != nil {
-		t.Fatal(err)
-	}
-	config := tls.Config{Certificates: []tls.Certificate{cer}, ClientAuth: tls.RequestClientCert}
-
 	s := server.New()
 
 	s.RegisterTitanHandler("/", func(r server.TitanRequest) {
+		body, err := r.ReadBody()
+		if err != nil {
+			t.Errorf("could not read body: %v", err)
+		}
+
 		b := gemtext.NewBuilder()
-		b.AddPreformattedText(string(r.Body))
+		b.AddPreformattedText(string(body))
 		b.AddHeader1Line(r.Token)
 		b.AddHeader2Line(r.MIMEType)
 
-		err := r.Gemtext(b.Get())
+		err = r.Gemtext(b.Get())
 		if err != nil {
 			t.Errorf("handler failed to respond to request: %v", err)
 		}
 	})
 
-	go func() {
-		s.ListenAndServe("localhost", &config)
-	}()
-
-	defer func(s *server.Server) {
-		err := s.Close()
-		if err != nil {
-			t.Errorf("Could not close server: %v", err)
-		}
-	}(s)
+	addr := listen(t, s)
 
 	clientConfig := tls.Config{InsecureSkipVerify: true}
-	response, err := TitanRequest("titan://localhost/", &clientConfig, []byte("Hello"), "tokenTester", "text/gemini")
+	response, err := client.TitanRequest("titan://"+addr+"/", &clientConfig, []byte("Hello"), "tokenTester", "text/gemini")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -212,12 +214,6 @@ func TestTitanRequestResponse(t *testing.T) {
 }
 
 func TestInputRequestResponse(t *testing.T) {
-	cer, err := tls.LoadX509KeyPair("../examples/cert.pem", "../examples/key.pem")
-	if err != nil {
-		t.Fatal(err)
-	}
-	config := tls.Config{Certificates: []tls.Certificate{cer}, ClientAuth: tls.RequestClientCert}
-
 	s := server.New()
 
 	s.RegisterHandler("/", func(r server.Request) {
@@ -233,19 +229,10 @@ func TestInputRequestResponse(t *testing.T) {
 		r.Gemtext(inp)
 	})
 
-	go func() {
-		s.ListenAndServe("localhost", &config)
-	}()
-
-	defer func(s *server.Server) {
-		err := s.Close()
-		if err != nil {
-			t.Errorf("Could not close server: %v", err)
-		}
-	}(s)
+	addr := listen(t, s)
 
 	clientConfig := tls.Config{InsecureSkipVerify: true}
-	response, err := Request("gemini://localhost/", &clientConfig)
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -258,7 +245,7 @@ func TestInputRequestResponse(t *testing.T) {
 		t.Fatalf("Response meta data is %s", response.MetaData)
 	}
 
-	response, err = Request("gemini://localhost/?Hello%2C%20World%21", &clientConfig)
+	response, err = client.Request("gemini://"+addr+"/?Hello%2C%20World%21", &clientConfig)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -284,3 +271,640 @@ func TestInputRequestResponse(t *testing.T) {
 		t.Fatalf("First line text is %s", textLine.Text)
 	}
 }
+
+func TestRequestTooLong(t *testing.T) {
+	s := server.New()
+
+	s.RegisterHandler("/:param", func(r server.Request) {
+		t.Errorf("handler called for request line over 1024 bytes")
+	})
+
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/"+strings.Repeat("a", 1100), &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 59 {
+		t.Fatalf("Response status code is %d", response.StatusCode)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	s := server.New()
+	s.HandlerTimeout = 50 * time.Millisecond
+
+	s.RegisterHandler("/", func(r server.Request) {
+		time.Sleep(200 * time.Millisecond)
+
+		err := r.Gemtext("Too late")
+		if err == nil {
+			t.Errorf("handler responded after timeout")
+		}
+	})
+
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 40 {
+		t.Fatalf("Response status code is %d", response.StatusCode)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := server.New()
+	s.SetRateLimiter(server.NewTokenBucket(0.1, 1), server.KeyByIP)
+	s.SetRouteRateLimiter("/unlimited", nil)
+
+	handler := func(r server.Request) {
+		err := r.Gemtext("Hello")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	}
+	s.RegisterHandler("/", handler)
+	s.RegisterHandler("/unlimited", handler)
+
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	for i, want := range []int{20, 44} {
+		response, err := client.Request("gemini://"+addr+"/", &clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != want {
+			t.Fatalf("Response %d status code is %d, want %d", i+1, response.StatusCode, want)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		response, err := client.Request("gemini://"+addr+"/unlimited", &clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != 20 {
+			t.Fatalf("Unlimited response status code is %d", response.StatusCode)
+		}
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	s := server.New()
+
+	s.Host("127.0.0.1").RegisterHandler("/", func(r server.Request) {
+		err := r.Gemtext("Hello")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+
+	addr := listen(t, s)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 20 {
+		t.Fatalf("Response status code is %d", response.StatusCode)
+	}
+
+	response, err = client.Request("gemini://localhost:"+port+"/", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 53 {
+		t.Fatalf("Response status code for unserved host is %d", response.StatusCode)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := server.New()
+	upstream.RegisterHandler("/pages/:page", func(r server.Request) {
+		err := r.Gemtext("# " + r.Params["page"] + "\r\n" + r.URI.RawQuery)
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+	upstreamAddr := listen(t, upstream)
+
+	proxy, err := server.ReverseProxy(server.ReverseProxyConfig{
+		Upstream:    "gemini://" + upstreamAddr + "/pages",
+		StripPrefix: "/wiki",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := server.New()
+	s.Handle("/wiki/:page", proxy)
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/wiki/home?query", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 20 {
+		t.Fatalf("Response status code is %d", response.StatusCode)
+	}
+
+	if string(response.Data) != "# home\r\nquery" {
+		t.Fatalf("Response data is %q", string(response.Data))
+	}
+
+	upstream.Close()
+
+	response, err = client.Request("gemini://"+addr+"/wiki/home", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 43 {
+		t.Fatalf("Response status code with upstream down is %d", response.StatusCode)
+	}
+}
+
+func TestTitanUploadSize(t *testing.T) {
+	s := server.New()
+	s.MaxUploadSize = 1024 * 1024
+	s.SetMaxUploadSize("/small", 10)
+
+	handler := func(r server.TitanRequest) {
+		body, err := r.ReadBody()
+		if err != nil {
+			t.Errorf("could not read body: %v", err)
+			return
+		}
+
+		err = r.Gemtext(strconv.Itoa(len(body)))
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	}
+	s.RegisterTitanHandler("/", handler)
+	s.RegisterTitanHandler("/small", handler)
+
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	body := []byte(strings.Repeat("a", 200*1024))
+	response, err := client.TitanRequest("titan://"+addr+"/", &clientConfig, body, "", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 20 || string(response.Data) != strconv.Itoa(len(body)) {
+		t.Fatalf("Response is %d %q", response.StatusCode, string(response.Data))
+	}
+
+	response, err = client.TitanRequest("titan://"+addr+"/small", &clientConfig, []byte("more than ten bytes"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 59 {
+		t.Fatalf("Response status code for upload over limit is %d", response.StatusCode)
+	}
+}
+
+func TestRequestContextDisconnect(t *testing.T) {
+	s := server.New()
+
+	cancelled := make(chan error, 1)
+	s.RegisterHandler("/", func(r server.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.Context().Err()
+		case <-time.After(time.Second):
+			cancelled <- nil
+		}
+	})
+
+	addr := listen(t, s)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Write([]byte("gemini://" + addr + "/\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	err = <-cancelled
+	if err != context.Canceled {
+		t.Fatalf("Context error is %v", err)
+	}
+}
+
+func TestRequestContextTimeout(t *testing.T) {
+	s := server.New()
+	s.HandlerTimeout = 50 * time.Millisecond
+
+	cancelled := make(chan error, 1)
+	s.RegisterHandler("/", func(r server.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Errorf("Context has no deadline")
+		}
+
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+	})
+
+	addr := listen(t, s)
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 40 {
+		t.Fatalf("Response status code is %d", response.StatusCode)
+	}
+
+	err = <-cancelled
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Context error is %v", err)
+	}
+}
+
+func TestRequestContextShutdown(t *testing.T) {
+	s := server.New()
+
+	started := make(chan struct{})
+	s.RegisterHandler("/", func(r server.Request) {
+		close(started)
+		<-r.Context().Done()
+
+		err := r.TemporaryFailure("Shutting down")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+
+	addr := listen(t, s)
+
+	go func() {
+		<-started
+		err := s.Shutdown(context.Background())
+		if err != nil {
+			t.Errorf("Could not shut down server: %v", err)
+		}
+	}()
+
+	clientConfig := tls.Config{InsecureSkipVerify: true}
+	response, err := client.Request("gemini://"+addr+"/", &clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 40 || response.MetaData != "Shutting down" {
+		t.Fatalf("Response is %d %s", response.StatusCode, response.MetaData)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestServerLogger(t *testing.T) {
+	var logs syncBuffer
+
+	s := server.New()
+	s.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	s.RegisterHandler("/", func(r server.Request) {
+		err := r.Gemtext("Hello")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+
+	addr := listen(t, s)
+
+	c := client.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	for _, path := range []string{"/", "/missing"} {
+		_, err := c.Request("gemini://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests are logged after the response has been written, so wait for both to appear
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(logs.String(), `"msg":"Request completed"`) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	output := logs.String()
+	for _, want := range []string{
+		`"msg":"Request completed"`,
+		`"path":"/"`,
+		`"status":20`,
+		`"bytes":5`,
+		`"duration":`,
+		`"status":51`,
+		`"remote_addr":"127.0.0.1:`,
+		`"msg":"Route not found"`,
+		`"path":"/missing"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Log does not contain %s:\n%s", want, output)
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s := server.New()
+
+	s.RegisterHandler("/users/:id", func(r server.Request) {
+		err := r.Gemtext("Hello")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+	s.RegisterTitanHandler("/upload", func(r server.TitanRequest) {
+		_, err := r.Respond("text/plain")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+
+	addr := listen(t, s)
+
+	c := client.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		_, err := c.Request("gemini://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := c.TitanRequest("titan://"+addr+"/upload", []byte("Hello"), "", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests are recorded after the response has been written, so wait for them to appear
+	var snapshot server.MetricsSnapshot
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		snapshot = s.Metrics().Snapshot()
+		if snapshot.ActiveConnections == 0 && snapshot.Connections == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := []server.RequestCount{
+		{Protocol: "gemini", Route: "", StatusClass: "5x", Count: 1},
+		{Protocol: "gemini", Route: "/users/:id", StatusClass: "2x", Count: 2},
+		{Protocol: "titan", Route: "/upload", StatusClass: "2x", Count: 1},
+	}
+	if len(snapshot.Requests) != len(want) {
+		t.Fatalf("Requests are %+v, want %+v", snapshot.Requests, want)
+	}
+	for i := range want {
+		if snapshot.Requests[i] != want[i] {
+			t.Errorf("Requests are %+v, want %+v", snapshot.Requests, want)
+		}
+	}
+
+	if snapshot.ResponseSize.Count != 3 || snapshot.ResponseSize.Sum != 10 {
+		t.Errorf("Response sizes are %+v", snapshot.ResponseSize)
+	}
+
+	if snapshot.UploadSize.Count != 1 || snapshot.UploadSize.Sum != 5 {
+		t.Errorf("Upload sizes are %+v", snapshot.UploadSize)
+	}
+
+	if len(snapshot.Latency) != 2 || snapshot.Latency[0].Route != "/users/:id" || snapshot.Latency[0].Count != 2 {
+		t.Errorf("Latencies are %+v", snapshot.Latency)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsAddr := l.Addr().String()
+	l.Close()
+
+	go s.ListenAndServeMetrics(metricsAddr)
+
+	var response *http.Response
+	deadline = time.Now().Add(time.Second)
+	for {
+		response, err = http.Get("http://" + metricsAddr + "/metrics")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"gomini_connections_total 4",
+		`gomini_requests_total{protocol="gemini",route="/users/:id",status_class="2x"} 2`,
+		`gomini_titan_upload_size_bytes_bucket{le="256"} 1`,
+		`gomini_handler_duration_seconds_count{protocol="titan",route="/upload"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Metrics do not contain %s:\n%s", line, body)
+		}
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	type report struct {
+		path  string
+		value any
+		stack string
+	}
+	reports := make(chan report, 1)
+
+	s := server.New()
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.OnPanic = func(request *server.Request, value any, stack []byte) {
+		reports <- report{path: request.Path(), value: value, stack: string(stack)}
+	}
+
+	s.RegisterHandler("/panic", func(r server.Request) {
+		panic("handler failed")
+	})
+	s.RegisterHandler("/partial", func(r server.Request) {
+		err := r.Gemtext("Partial")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+		panic("handler failed after responding")
+	})
+
+	addr := listen(t, s)
+
+	c := client.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	response, err := c.Request("gemini://" + addr + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 40 {
+		t.Errorf("Response status code is %d", response.StatusCode)
+	}
+
+	select {
+	case r := <-reports:
+		if r.path != "/panic" || r.value != "handler failed" {
+			t.Errorf("Unexpected panic report %+v", r)
+		}
+		if !strings.Contains(r.stack, "TestHandlerPanic") {
+			t.Errorf("Stack does not contain the handler:\n%s", r.stack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnPanic was not called")
+	}
+
+	response, err = c.Request("gemini://" + addr + "/partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != 20 || string(response.Data) != "Partial" {
+		t.Errorf("Response is %d %q", response.StatusCode, response.Data)
+	}
+
+	select {
+	case r := <-reports:
+		if r.value != "handler failed after responding" {
+			t.Errorf("Unexpected panic report %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnPanic was not called")
+	}
+}
+
+func TestErrorHandlers(t *testing.T) {
+	var mu sync.Mutex
+	var reported []error
+
+	s := server.New()
+	s.OnError = func(request *server.Request, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	}
+	s.NotFoundHandler = server.RequestHandlerFunc(func(r server.Request) {
+		if r.URI.Path == "/old" {
+			r.Redirect("/new")
+			return
+		}
+
+		if r.URI.Path == "/silent" {
+			return
+		}
+
+		err := r.Error(server.StatusNotFound, "Nothing at "+r.URI.Scheme+" "+r.Path())
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+	s.BadRequestHandler = server.RequestHandlerFunc(func(r server.Request) {
+		err := r.Error(server.StatusBadRequest, "Please check the URL")
+		if err != nil {
+			t.Errorf("handler failed to respond to request: %v", err)
+		}
+	})
+
+	addr := listen(t, s)
+
+	c := client.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	tests := []struct {
+		url    string
+		titan  bool
+		status int
+		meta   string
+	}{
+		{url: "gemini://" + addr + "/missing", status: 51, meta: "Nothing at gemini /missing"},
+		{url: "gemini://" + addr + "/old", status: 30, meta: "/new"},
+		{url: "gemini://" + addr + "/silent", status: 51, meta: "Not Found"},
+		{url: "titan://" + addr + "/missing", titan: true, status: 51, meta: "Nothing at titan /missing"},
+		{url: "gemini://" + addr + "/" + strings.Repeat("a", 1100), status: 59, meta: "Please check the URL"},
+	}
+
+	for _, test := range tests {
+		var response *client.Response
+		var err error
+		if test.titan {
+			response, err = c.TitanRequest(test.url, []byte("Hello"), "", "text/plain")
+		} else {
+			response, err = c.Request(test.url)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != test.status || response.MetaData != test.meta {
+			t.Errorf("Response is %d %s, want %d %s", response.StatusCode, response.MetaData, test.status, test.meta)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(reported) != len(tests) {
+		t.Fatalf("OnError was called with %v", reported)
+	}
+
+	for _, err := range reported[:4] {
+		if !errors.Is(err, server.ErrRouteNotFound) {
+			t.Errorf("OnError was called with %v, want ErrRouteNotFound", err)
+		}
+	}
+
+	if !errors.Is(reported[4], server.ErrRequestTooLong) {
+		t.Errorf("OnError was called with %v, want ErrRequestTooLong", reported[4])
+	}
+}
@@ -1,10 +1,9 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
-	log "github.com/sirupsen/logrus"
 	"io"
-	"net"
 	"net/url"
 	"strconv"
 	"strings"
@@ -15,6 +14,13 @@ import (
 // If token or mime is not desired, an empty string can be passed.
 // The same caveat for tlsConfig as [Request] applies.
 func TitanRequest(address string, tlsConfig *tls.Config, body []byte, token string, mime string) (*Response, error) {
+	return (&Client{TLSConfig: tlsConfig}).TitanRequest(address, body, token, mime)
+}
+
+// TitanRequest uploads body to a Titan server at address.
+//
+// If token or mime is not desired, an empty string can be passed.
+func (c *Client) TitanRequest(address string, body []byte, token string, mime string) (*Response, error) {
 	if mime == "" {
 		mime = "text/gemini"
 	}
@@ -23,17 +29,15 @@ func TitanRequest(address string, tlsConfig *tls.Config, body []byte, token stri
 	if err != nil {
 		return nil, err
 	}
-	connInsecure, err := net.Dial("tcp", parsedURL.Host+":1965")
+	conn, err := c.dial(context.Background(), parsedURL)
 	if err != nil {
 		return nil, err
 	}
 
-	conn := tls.Client(connInsecure, tlsConfig)
-
 	defer func(conn *tls.Conn) {
 		err := conn.Close()
 		if err != nil {
-			log.Errorf("%v", err)
+			c.logger().Error("Could not close connection", "url", address, "error", err)
 		}
 	}(conn)
 
@@ -59,6 +63,7 @@ func TitanRequest(address string, tlsConfig *tls.Config, body []byte, token stri
 		return nil, err
 	}
 	metaData := strings.Join(headerParts[1:], " ")
+	c.logger().Debug("Received response", "url", address, "status", statusCode, "meta", metaData)
 
 	return &Response{
 		StatusCode: statusCode,
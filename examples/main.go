@@ -1,40 +1,54 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"github.com/nailuj29/gomini/gemtext"
 	"github.com/nailuj29/gomini/server"
-
-	log "github.com/sirupsen/logrus"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
 )
 
 func main() {
 	cer, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("could not load certificate", "error", err)
+		os.Exit(1)
 	}
 	config := tls.Config{Certificates: []tls.Certificate{cer}, ClientAuth: tls.RequestClientCert}
 
 	s := server.New()
+	s.Use(server.Recover())
+
+	s.NotFoundHandler = server.RequestHandlerFunc(func(request server.Request) {
+		err := request.Error(server.StatusNotFound, "There is nothing at "+request.URI.Path)
+		if err != nil {
+			request.Logger().Error("could not respond", "error", err)
+		}
+	})
 
 	s.RegisterHandler("/", func(request server.Request) {
 		err := request.GemtextFile("index.gmi")
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
 	s.RegisterHandler("/test1", func(request server.Request) {
 		err := request.Gemtext("# Test 1!\r\nThis is the first test page")
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
 	s.RegisterHandler("/test2", func(request server.Request) {
 		err := request.Gemtext("# Test 2!\r\nThis is the second test page")
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
@@ -58,27 +72,37 @@ func main() {
 
 		err := request.Gemtext(b.Get())
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
-	s.RegisterHandler("/secure", func(request server.Request) {
-		certs := request.GetClientCertificates()
-
-		if len(certs) == 0 {
-			err := request.Error(60, "Cert required")
-			if err != nil {
-				log.Fatalf("could not respond: %v", err)
-			}
+	s.RegisterHandler("/plain", func(request server.Request) {
+		w, err := request.Respond("text/plain")
+		if err != nil {
+			request.Logger().Error("could not respond", "error", err)
 			return
 		}
 
-		err := request.Gemtext("# Secure page\r\nWelcome!")
+		_, err = io.WriteString(w, "This is a plain text page.\r\n# This is not a header")
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
+	s.HandleFunc("/writer", func(w server.ResponseWriter, r *server.Request) {
+		_, err := io.WriteString(w, "# ResponseWriter\r\nThis page was written without calling the methods of the request")
+		if err != nil {
+			r.Logger().Error("could not respond", "error", err)
+		}
+	})
+
+	s.RegisterHandler("/secure", func(request server.Request) {
+		err := request.Gemtext("# Secure page\r\nWelcome!")
+		if err != nil {
+			request.Logger().Error("could not respond", "error", err)
+		}
+	}, server.RequireCertificate("Cert required"))
+
 	s.RegisterHandler("/dynamic/:dynamic", func(request server.Request) {
 		param := request.Params["dynamic"]
 
@@ -89,21 +113,52 @@ func main() {
 
 		err := request.Gemtext(b.Get())
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			request.Logger().Error("could not respond", "error", err)
 		}
 	})
 
 	s.RegisterTitanHandler("/", func(r server.TitanRequest) {
-		log.Infof("Titan request recieved. Body: \n```\n%s\n```", string(r.Body))
-		err := r.Gemtext(string(r.Body))
+		body, err := r.ReadBody()
 		if err != nil {
-			log.Fatalf("could not respond: %v", err)
+			r.Logger().Error("could not read body", "error", err)
 			return
 		}
+
+		r.Logger().Info("Titan request received", "body", string(body))
+		err = r.Gemtext(string(body))
+		if err != nil {
+			r.Logger().Error("could not respond", "error", err)
+		}
 	})
 
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := s.Shutdown(ctx)
+		if err != nil {
+			slog.Error("could not shut down gracefully", "error", err)
+		}
+	}()
+
+	go func() {
+		err := s.ListenAndServeMetrics("localhost:9100")
+		if err != nil && !errors.Is(err, server.ErrServerClosed) {
+			slog.Error("could not serve metrics", "error", err)
+		}
+	}()
+
 	err = s.ListenAndServe("localhost", &config)
-	if err != nil {
-		log.Fatalf("could not respond: %v", err)
+	if err != nil && !errors.Is(err, server.ErrServerClosed) {
+		slog.Error("could not serve", "error", err)
+		os.Exit(1)
 	}
+
+	<-shutdown
 }
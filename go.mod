@@ -1,7 +1,3 @@
 module github.com/nailuj29/gomini
 
 go 1.21
-
-require github.com/sirupsen/logrus v1.9.3
-
-require golang.org/x/sys v0.13.0 // indirect
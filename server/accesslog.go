@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// An AccessLogFormat is a format of the lines written by [AccessLog] or to the AccessLog of a [Server]
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat writes lines similar to the Common Log Format of HTTP servers, prefixed with the SNI host:
+	//
+	//	example.org 192.0.2.1 - 1f2e... [18/Oct/2026:13:55:36 +0000] "gemini://example.org/" 20 1024 "text/gemini" 0.012
+	//
+	// The fields are the SNI host, the IP address of the client, the fingerprint of the client certificate, the time
+	// the request was received, the URL, the status code, the number of response body bytes, the meta and the
+	// duration in seconds. Missing values are written as "-", and the URL and meta are quoted and escaped as Go
+	// strings, so that clients cannot forge log lines.
+	CommonLogFormat AccessLogFormat = iota
+	// JSONLogFormat writes each request as a JSON object on its own line, with the fields time, host, remote_ip,
+	// fingerprint, url, status, meta, bytes and duration (in seconds).
+	JSONLogFormat
+)
+
+// accessLogEntry is a request recorded in an access log
+type accessLogEntry struct {
+	Time        time.Time `json:"time"`
+	Host        string    `json:"host"`
+	RemoteIP    string    `json:"remote_ip"`
+	Fingerprint string    `json:"fingerprint"`
+	URL         string    `json:"url"`
+	Status      int       `json:"status"`
+	Meta        string    `json:"meta"`
+	Bytes       int64     `json:"bytes"`
+	Duration    float64   `json:"duration"`
+}
+
+// AccessLog creates a [Middleware] writing a line to w for every request once it has been handled, in the given
+// format. It can be applied to some routes, routers or virtual hosts only; to log every request, including requests
+// which could not be routed, set the AccessLog field of the [Server] instead. Requests whose handler panics are
+// logged too, with status 0 unless [Recover] is applied inside AccessLog.
+// Lines are written with a single call to Write, and the calls of a middleware are serialised, so w need not be safe
+// for concurrent use unless it is shared. To rotate log files, use a [LogFile] as w.
+func AccessLog(w io.Writer, format AccessLogFormat) Middleware {
+	var mu sync.Mutex
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(rw ResponseWriter, request *Request) {
+			start := time.Now()
+			defer func() {
+				writeAccessLog(w, &mu, format, request, start, time.Since(start))
+			}()
+
+			next.ServeGemini(rw, request)
+		})
+	}
+}
+
+// writeAccessLog writes a line for the request served by the [Server] to its AccessLog
+func (s *Server) writeAccessLog(request *Request, start time.Time, duration time.Duration) {
+	if s.AccessLog == nil {
+		return
+	}
+
+	writeAccessLog(s.AccessLog, &s.accessLogMu, s.AccessLogFormat, request, start, duration)
+}
+
+// writeAccessLog writes a line in format to w while holding mu, for request, received at start and answered after
+// duration
+func writeAccessLog(w io.Writer, mu *sync.Mutex, format AccessLogFormat, request *Request, start time.Time,
+	duration time.Duration) {
+	entry := accessLogEntry{
+		Time:        start,
+		Host:        request.ServerName(),
+		RemoteIP:    remoteIP(request),
+		Fingerprint: request.ClientFingerprint(),
+		URL:         request.URI.String(),
+		Status:      request.Status(),
+		Meta:        request.Meta(),
+		Bytes:       request.BytesWritten(),
+		Duration:    duration.Seconds(),
+	}
+
+	line, err := entry.format(format)
+	if err != nil {
+		request.Logger().Error("Could not format access log entry", "error", err)
+		return
+	}
+
+	mu.Lock()
+	_, err = w.Write(line)
+	mu.Unlock()
+	if err != nil {
+		request.Logger().Error("Could not write access log", "error", err)
+	}
+}
+
+// format encodes the entry as a line in format
+func (e accessLogEntry) format(format AccessLogFormat) ([]byte, error) {
+	if format == JSONLogFormat {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(line, '\n'), nil
+	}
+
+	line := fmt.Sprintf("%s %s - %s [%s] %s %d %d %s %.3f\n",
+		orDash(e.Host),
+		orDash(e.RemoteIP),
+		orDash(e.Fingerprint),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.URL),
+		e.Status,
+		e.Bytes,
+		strconv.Quote(e.Meta),
+		e.Duration,
+	)
+
+	return []byte(line), nil
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// remoteIP returns the IP address of the client that made request, or its full address if it has no port
+func remoteIP(request *Request) string {
+	addr := request.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// A LogFile is an [io.Writer] appending to a file, which can be reopened so that log files can be rotated by
+// renaming them. It is safe for concurrent use.
+type LogFile struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	stop chan struct{}
+}
+
+// OpenLogFile opens the file at path for appending, creating it if it does not exist
+func OpenLogFile(path string) (*LogFile, error) {
+	f := &LogFile{path: path}
+	err := f.Reopen()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write appends p to the file
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	return f.file.Write(p)
+}
+
+// Reopen closes the file and opens the file at its path again, creating it if it does not exist.
+// After a log rotation tool renames the file, calling Reopen continues logging to a new file.
+func (f *LogFile) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.file
+	f.file = file
+	if old != nil {
+		return old.Close()
+	}
+
+	return nil
+}
+
+// ReopenOnSignal reopens the file whenever the process receives one of signals, or SIGHUP if none are given, until
+// the file is closed. Errors reopening the file are passed to onError if it is not nil; the previous file remains in
+// use.
+func (f *LogFile) ReopenOnSignal(onError func(err error), signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+
+	f.mu.Lock()
+	if f.stop == nil {
+		f.stop = make(chan struct{})
+	}
+	stop := f.stop
+	f.mu.Unlock()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+
+	go func() {
+		defer signal.Stop(sig)
+
+		for {
+			select {
+			case <-sig:
+				err := f.Reopen()
+				if err != nil && onError != nil {
+					onError(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close closes the file, and stops reopening it on signals
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a [bytes.Buffer] safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf syncBuffer
+	s := New()
+	s.RegisterHandler("/path", func(request Request) {
+		request.Gemtext("hello")
+	}, AccessLog(&buf, CommonLogFormat))
+	s.RegisterHandler("/unlogged", func(request Request) {
+		request.Gemtext("hello")
+	})
+	s.RegisterHandler("/panic", func(request Request) {
+		panic("oops")
+	}, AccessLog(&buf, CommonLogFormat))
+
+	addr, _ := listenTest(t, s)
+	for _, path := range []string{"/path?query", "/unlogged", "/panic"} {
+		io.ReadAll(sendRequest(t, addr, "gemini://localhost"+path))
+	}
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+
+	// The panic is recovered by the server outside the middleware, so no status has been written when it is logged
+	patterns := []string{
+		`"gemini://localhost/path\?query" 20 5 "text/gemini"`,
+		`"gemini://localhost/panic" 0 0 ""`,
+	}
+	for i, pattern := range patterns {
+		pattern = `^- 127\.0\.0\.1 - - \[[^]]+\] ` + pattern + ` [0-9]+\.[0-9]{3}\n$`
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("unexpected log line %q", lines[i])
+		}
+	}
+}
+
+func TestServer_AccessLog_common(t *testing.T) {
+	var buf syncBuffer
+	s := New()
+	s.AccessLog = &buf
+	s.RegisterHandler("/path", func(request Request) {
+		request.Gemtext("hello")
+	})
+	s.RegisterHandler("/panic", func(request Request) {
+		panic("oops")
+	})
+
+	addr, _ := listenTest(t, s)
+	tests := []struct {
+		uri     string
+		pattern string
+	}{
+		{"gemini://localhost/path?query", `"gemini://localhost/path\?query" 20 5 "text/gemini"`},
+		{"gemini://localhost/missing", `"gemini://localhost/missing" 51 0 "Not Found"`},
+		{"gemini://localhost/panic", `"gemini://localhost/panic" 40 0 "Temporary failure"`},
+		{"gemini://localhost/" + strings.Repeat("a", 1100), `"" 59 0 "Request too long"`},
+	}
+
+	for _, test := range tests {
+		io.ReadAll(sendRequest(t, addr, test.uri))
+	}
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if len(lines) != len(tests)+1 {
+		t.Fatalf("expected %d log lines, got %q", len(tests), buf.String())
+	}
+
+	for i, test := range tests {
+		pattern := `^- 127\.0\.0\.1 - - \[[^]]+\] ` + test.pattern + ` [0-9]+\.[0-9]{3}\n$`
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("%s: unexpected log line %q", test.uri, lines[i])
+		}
+	}
+}
+
+func TestServer_AccessLog_json(t *testing.T) {
+	var buf syncBuffer
+	s := New()
+	s.AccessLog = &buf
+	s.AccessLogFormat = JSONLogFormat
+	s.RegisterHandler("/missing", func(request Request) {
+		request.NotFound("Nothing \"here\"")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert := newTestCertificate(t, "localhost", false, nil)
+	go s.Serve(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{*serverCert},
+		ClientAuth:   tls.RequestClientCert,
+	}))
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	clientCert := newTestCertificate(t, "client", false, nil)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		ServerName:         "example.org",
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{*clientCert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "gemini://example.org/missing\r\n")
+	io.ReadAll(conn)
+
+	var entry accessLogEntry
+	err = json.Unmarshal([]byte(buf.String()), &entry)
+	if err != nil {
+		t.Fatalf("invalid log line %q: %v", buf.String(), err)
+	}
+
+	if entry.URL != "gemini://example.org/missing" || entry.Status != StatusNotFound || entry.Meta != "Nothing \"here\"" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if entry.Host != "example.org" || entry.RemoteIP != "127.0.0.1" || entry.Bytes != 0 {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if entry.Fingerprint != Fingerprint(clientCert.Leaf) {
+		t.Errorf("expected the client certificate fingerprint, got %q", entry.Fingerprint)
+	}
+}
+
+func TestLogFile_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	f, err := OpenLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("first\n"))
+
+	err = os.Rename(path, path+".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("second\n"))
+	err = f.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("third\n"))
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := os.ReadFile(path + ".1")
+	if string(rotated) != "first\nsecond\n" {
+		t.Errorf("rotated file contains %q", rotated)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "third\n" {
+		t.Errorf("current file contains %q", current)
+	}
+
+	_, err = f.Write([]byte("closed\n"))
+	if err == nil {
+		t.Error("expected an error writing to a closed file")
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Authorizer grants roles to clients based on their certificates.
+// A client can be granted a role either by the [Fingerprint] of its certificate, or by having a certificate issued by
+// a trusted CA. Routes declare the roles they require with [Authorizer.Require].
+// An Authorizer is safe for concurrent use.
+type Authorizer struct {
+	mu           sync.RWMutex
+	fingerprints map[string][]string
+	pools        map[string][]*x509.CertPool
+}
+
+// NewAuthorizer creates an [Authorizer] which grants no roles
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{
+		fingerprints: make(map[string][]string),
+		pools:        make(map[string][]*x509.CertPool),
+	}
+}
+
+// GrantFingerprint grants a role to the clients whose certificates have the given fingerprints
+func (a *Authorizer) GrantFingerprint(role string, fingerprints ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, fingerprint := range fingerprints {
+		fingerprint = strings.ToLower(fingerprint)
+		a.fingerprints[fingerprint] = append(a.fingerprints[fingerprint], role)
+	}
+}
+
+// GrantCA grants a role to the clients whose certificates are issued by a CA in pool.
+// A role can be granted to the clients of several pools by calling GrantCA once for each pool.
+func (a *Authorizer) GrantCA(role string, pool *x509.CertPool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pools[role] = append(a.pools[role], pool)
+}
+
+// LoadCertPool loads a pool of CA certificates from a PEM file, for use with [Authorizer.GrantCA]
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+
+	return pool, nil
+}
+
+var errCertificateNotValid = errors.New("certificate not valid")
+
+// Roles returns the roles granted to the client making a [Request].
+// It returns an error if the client certificate has expired, is not yet valid, or is issued by a trusted CA but
+// cannot be verified.
+func (a *Authorizer) Roles(request Request) ([]string, error) {
+	certs := request.GetClientCertificates()
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	cert := certs[0]
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, errCertificateNotValid
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	roles := append([]string(nil), a.fingerprints[Fingerprint(cert)]...)
+
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range certs[1:] {
+		intermediates.AddCert(intermediate)
+	}
+
+	invalid := false
+	for role, pools := range a.pools {
+		for _, pool := range pools {
+			_, err := cert.Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+				CurrentTime:   now,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			})
+
+			var invalidErr x509.CertificateInvalidError
+			if err == nil {
+				roles = append(roles, role)
+				break
+			} else if errors.As(err, &invalidErr) {
+				invalid = true
+			}
+		}
+	}
+
+	if len(roles) == 0 && invalid {
+		return nil, errCertificateNotValid
+	}
+
+	return roles, nil
+}
+
+// Require creates a [Middleware] allowing only clients granted at least one of roles to access a route.
+// Clients without a certificate receive status code 60, clients whose certificate is not valid receive status code
+// 62, and clients without any of the roles receive status code 61.
+func (a *Authorizer) Require(roles ...string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			if len(request.GetClientCertificates()) == 0 {
+				request.logWriteError(request.CertificateRequired("Certificate required"))
+				return
+			}
+
+			granted, err := a.Roles(*request)
+			if err != nil {
+				request.logWriteError(request.CertificateNotValid("Certificate not valid"))
+				return
+			}
+
+			for _, role := range roles {
+				for _, grantedRole := range granted {
+					if role == grantedRole {
+						next.ServeGemini(w, request)
+						return
+					}
+				}
+			}
+
+			request.logWriteError(request.CertificateNotAuthorized("Certificate not authorised"))
+		})
+	}
+}
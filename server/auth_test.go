@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestAuthorizer_Require(t *testing.T) {
+	ca := newTestCertificate(t, "Staff CA", true, nil)
+	otherCA := newTestCertificate(t, "Other CA", true, nil)
+	admin := newTestCertificate(t, "admin", false, nil)
+	staff := newTestCertificate(t, "staff", false, ca)
+	stranger := newTestCertificate(t, "stranger", false, otherCA)
+	expiredStaff := signTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "former staff"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	auth := NewAuthorizer()
+	auth.GrantFingerprint("admin", Fingerprint(admin.Leaf))
+	auth.GrantCA("staff", pool)
+
+	handler := chain(RequestHandlerFunc(func(request Request) {
+		request.logWriteError(request.Gemtext("Welcome"))
+	}), []Middleware{auth.Require("admin", "staff")})
+	adminOnly := chain(RequestHandlerFunc(func(request Request) {
+		request.logWriteError(request.Gemtext("Welcome"))
+	}), []Middleware{auth.Require("admin")})
+
+	tests := []struct {
+		name    string
+		handler Handler
+		cert    *tls.Certificate
+		want    string
+	}{
+		{"admin", handler, admin, "20 text/gemini\r\nWelcome"},
+		{"staff", handler, staff, "20 text/gemini\r\nWelcome"},
+		{"staff on admin route", adminOnly, staff, "61 Certificate not authorised\r\n"},
+		{"stranger", handler, stranger, "61 Certificate not authorised\r\n"},
+		{"expired staff", handler, expiredStaff, "62 Certificate not valid\r\n"},
+	}
+
+	for _, test := range tests {
+		got := serveTLSTest(t, test.handler, "gemini://localhost/", test.cert)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	got := serveTLSTest(t, handler, "gemini://localhost/", nil)
+	if got != "60 Certificate required\r\n" {
+		t.Errorf("without certificate: got %q", got)
+	}
+}
+
+func TestAuthorizer_GrantCA_multiple(t *testing.T) {
+	caA := newTestCertificate(t, "CA A", true, nil)
+	caB := newTestCertificate(t, "CA B", true, nil)
+
+	poolA := x509.NewCertPool()
+	poolA.AddCert(caA.Leaf)
+	poolB := x509.NewCertPool()
+	poolB.AddCert(caB.Leaf)
+
+	auth := NewAuthorizer()
+	auth.GrantCA("admin", poolA)
+	auth.GrantCA("admin", poolB)
+
+	handler := chain(RequestHandlerFunc(func(request Request) {
+		roles, err := auth.Roles(request)
+		if err != nil || len(roles) != 1 || roles[0] != "admin" {
+			t.Errorf("Roles returned %v, %v, want [admin]", roles, err)
+		}
+		request.logWriteError(request.Gemtext("Welcome"))
+	}), []Middleware{auth.Require("admin")})
+
+	for _, ca := range []*tls.Certificate{caA, caB} {
+		cert := newTestCertificate(t, "admin of "+ca.Leaf.Subject.CommonName, false, ca)
+		got := serveTLSTest(t, handler, "gemini://localhost/", cert)
+		if got != "20 text/gemini\r\nWelcome" {
+			t.Errorf("certificate issued by %s: got %q", ca.Leaf.Subject.CommonName, got)
+		}
+	}
+}
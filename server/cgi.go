@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CGIConfig configures a [Handler] created by [CGIHandler]
+type CGIConfig struct {
+	// Path is the path of the executable to run
+	Path string
+	// Args contains additional arguments passed to the executable
+	Args []string
+	// Dir is the working directory of the executable. If empty, the working directory of the server is used
+	Dir string
+	// Env contains additional environment variables, in the form "KEY=value"
+	Env []string
+	// ScriptName is the URL path the script is served at. The rest of the request path is passed to the script as
+	// PATH_INFO. If empty, the mount point of the [Router] serving the script is used
+	ScriptName string
+	// Timeout is the maximum duration the script may run for. Defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// CGIHandler creates a [Handler] that runs a CGI script to answer each request.
+//
+// The script receives information about the request through the conventional Gemini CGI environment variables,
+// including GATEWAY_INTERFACE, SERVER_PROTOCOL, GEMINI_URL, PATH_INFO, QUERY_STRING and REMOTE_ADDR, along with
+// TLS_CLIENT_HASH, TLS_CLIENT_SUBJECT and related variables when the client provides a certificate.
+// The standard output of the script is sent to the client as the full response, including the status line.
+// If the script fails, times out or writes an invalid status line, or the handler panics, the client receives status
+// code 42.
+func CGIHandler(config CGIConfig) Handler {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return RequestHandlerFunc(config.serve)
+}
+
+func (c CGIConfig) serve(request Request) {
+	defer cgiPanic(request)
+
+	ctx, cancel := context.WithTimeout(request.Context(), c.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(cgiEnv(request, c.ScriptName), c.Env...)
+	cmd.WaitDelay = time.Second
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		request.Logger().Error("Could not run CGI script", "script", c.Path, "error", err)
+		cgiError(request)
+		return
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		request.Logger().Error("Could not run CGI script", "script", c.Path, "error", err)
+		cgiError(request)
+		return
+	}
+
+	reader := bufio.NewReader(stdout)
+	code, meta, err := readCGIHeader(reader)
+	if err == nil {
+		err = request.writeHeader(code, meta)
+	}
+
+	if err != nil {
+		request.Logger().Error("CGI script did not write a valid header", "script", c.Path, "error", err)
+		cgiError(request)
+	} else if code == StatusSuccess {
+		_, err = io.Copy(request.w, reader)
+		if err != nil {
+			request.logWriteError(err)
+		}
+	}
+
+	_, _ = io.Copy(io.Discard, reader)
+	err = cmd.Wait()
+	if err != nil {
+		request.Logger().Error("CGI script failed", "script", c.Path, "error", err, "stderr", stderr.String())
+	}
+}
+
+func cgiError(request Request) {
+	if request.terminated() {
+		return
+	}
+
+	request.logWriteError(request.Error(StatusCGIError, "CGI error"))
+}
+
+// cgiPanic answers request with status code 42 if a CGI-like handler panics before responding, then continues
+// panicking so that the panic is reported by the [Server]. It must be deferred directly.
+func cgiPanic(request Request) {
+	if value := recover(); value != nil {
+		cgiError(request)
+		panic(value)
+	}
+}
+
+// readCGIHeader reads the status line written by a CGI script. Both CRLF and LF line endings are accepted.
+func readCGIHeader(reader *bufio.Reader) (int, string, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(line) > MaxMetaLength+5 {
+		return 0, "", ErrMetaTooLong
+	}
+
+	header := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	status, meta, _ := strings.Cut(header, " ")
+	code, err := strconv.Atoi(status)
+	if err != nil || len(status) != 2 {
+		return 0, "", ErrInvalidStatus
+	}
+
+	return code, meta, nil
+}
+
+// cgiEnv builds the environment variables passed to a CGI script
+func cgiEnv(request Request, scriptName string) []string {
+	uri := request.URI
+	pathInfo := request.Path()
+	if scriptName != "" {
+		pathInfo = strings.TrimPrefix(uri.Path, strings.TrimSuffix(scriptName, "/"))
+	} else {
+		scriptName = request.MountPoint()
+	}
+
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_PROTOCOL=" + strings.ToUpper(schemeOf(request)),
+		"SERVER_SOFTWARE=gomini",
+		"GEMINI_URL=" + uri.String(),
+		"SCRIPT_NAME=" + scriptName,
+		"PATH_INFO=" + pathInfo,
+		"QUERY_STRING=" + uri.RawQuery,
+	}
+
+	serverName := uri.Hostname()
+	if addr := request.LocalAddr(); addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			if serverName == "" {
+				serverName = host
+			}
+			env = append(env, "SERVER_PORT="+port)
+		}
+	}
+	env = append(env, "SERVER_NAME="+serverName)
+
+	if addr := request.RemoteAddr(); addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			env = append(env, "REMOTE_ADDR="+host, "REMOTE_HOST="+host, "REMOTE_PORT="+port)
+		}
+	}
+
+	if tlsConn, ok := request.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		env = append(env,
+			"TLS_VERSION="+tls.VersionName(state.Version),
+			"TLS_CIPHER="+tls.CipherSuiteName(state.CipherSuite),
+		)
+	}
+
+	certs := request.GetClientCertificates()
+	if len(certs) > 0 {
+		cert := certs[0]
+		env = append(env,
+			"AUTH_TYPE=CERTIFICATE",
+			"REMOTE_USER="+cert.Subject.CommonName,
+			"TLS_CLIENT_HASH="+Fingerprint(cert),
+			"TLS_CLIENT_SUBJECT="+cert.Subject.String(),
+			"TLS_CLIENT_SUBJECT_CN="+cert.Subject.CommonName,
+			"TLS_CLIENT_ISSUER="+cert.Issuer.String(),
+			"TLS_CLIENT_SERIAL_NUMBER="+cert.SerialNumber.String(),
+			"TLS_CLIENT_NOT_BEFORE="+cert.NotBefore.UTC().Format(time.RFC3339),
+			"TLS_CLIENT_NOT_AFTER="+cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+
+	return env
+}
+
+func schemeOf(request Request) string {
+	if request.URI.Scheme == "" {
+		return "gemini"
+	}
+
+	return request.URI.Scheme
+}
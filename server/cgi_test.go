@@ -0,0 +1,88 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeScript writes an executable shell script to a temporary directory, returning its path
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("CGI tests require a POSIX shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "script.sh")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCGIHandler(t *testing.T) {
+	script := writeScript(t, `printf '20 text/plain\r\n'
+printf '%s %s %s %s' "$SERVER_PROTOCOL" "$SCRIPT_NAME" "$PATH_INFO" "$QUERY_STRING"
+`)
+
+	handler := CGIHandler(CGIConfig{Path: script, ScriptName: "/cgi/"})
+	got := serveTest(t, handler, "gemini://localhost/cgi/extra/path?query")
+	want := "20 text/plain\r\nGEMINI /cgi/ /extra/path query"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCGIHandler_Status(t *testing.T) {
+	script := writeScript(t, `echo "30 gemini://localhost/elsewhere"
+echo "ignored body"
+`)
+
+	got := serveTest(t, CGIHandler(CGIConfig{Path: script}), "gemini://localhost/")
+	want := "30 gemini://localhost/elsewhere\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCGIHandler_Failure(t *testing.T) {
+	tests := map[string]CGIConfig{
+		"exit":           {Path: writeScript(t, "exit 1\n")},
+		"invalid header": {Path: writeScript(t, "echo 'Hello, World!'\n")},
+		"timeout":        {Path: writeScript(t, "sleep 5\n"), Timeout: 100 * time.Millisecond},
+		"missing":        {Path: filepath.Join(t.TempDir(), "missing")},
+	}
+
+	for name, config := range tests {
+		got := serveTest(t, CGIHandler(config), "gemini://localhost/")
+		if got != "42 CGI error\r\n" {
+			t.Errorf("%s: got %q, want %q", name, got, "42 CGI error\r\n")
+		}
+	}
+}
+
+func TestCGIPanic(t *testing.T) {
+	handler := RequestHandlerFunc(func(request Request) {
+		defer func() {
+			if value := recover(); value != "script failed" {
+				t.Errorf("recovered %v, want the original panic", value)
+			}
+		}()
+
+		func() {
+			defer cgiPanic(request)
+			panic("script failed")
+		}()
+	})
+
+	got := serveTest(t, handler, "gemini://localhost/")
+	want := "42 CGI error\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nailuj29/gomini/gemtext"
+)
+
+// A FileServerOption configures a [Handler] created by [FileServer]
+type FileServerOption func(*fileServer)
+
+// WithDirectoryListing makes a [FileServer] generate a gemtext listing for directories without an index.gmi file.
+// Without this option, such directories are answered with status code 51.
+func WithDirectoryListing() FileServerOption {
+	return func(f *fileServer) {
+		f.listDirectories = true
+	}
+}
+
+type fileServer struct {
+	root            fs.FS
+	listDirectories bool
+}
+
+// FileServer creates a [Handler] that serves files from root, using the path of the [Request] as the path of the file.
+// It should usually be registered on a dynamic route matching every path, such as "/*path". When registered on a
+// mounted [Router], paths are relative to the mount point, as returned by [Request.Path].
+//
+// Paths are cleaned before use, so requests cannot escape root. The MIME type of each file is detected from its
+// extension, with .gmi and .gemini files served as text/gemini. Requests for a directory are answered with its
+// index.gmi file. Files are streamed to the client, rather than being read into memory.
+func FileServer(root fs.FS, options ...FileServerOption) Handler {
+	f := &fileServer{root: root}
+	for _, option := range options {
+		option(f)
+	}
+
+	return RequestHandlerFunc(f.serve)
+}
+
+func (f *fileServer) serve(request Request) {
+	err := f.serveFile(request, request.Path())
+	if err != nil {
+		request.Logger().Error("Could not serve file", "error", err)
+	}
+}
+
+func (f *fileServer) serveFile(request Request, requestPath string) error {
+	name := cleanFilePath(requestPath)
+
+	info, err := fs.Stat(f.root, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			return request.NotFound("Not found")
+		}
+
+		return request.TemporaryFailure("Could not read file")
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(request.URI.Path, "/") {
+			target := request.URI
+			target.Path += "/"
+			return request.PermanentRedirect(target.String())
+		}
+
+		index := path.Join(name, "index.gmi")
+		if _, err := fs.Stat(f.root, index); err == nil {
+			return f.streamFile(request, index)
+		}
+
+		if f.listDirectories {
+			return f.listDirectory(request, name, requestPath)
+		}
+
+		return request.NotFound("Not found")
+	}
+
+	return f.streamFile(request, name)
+}
+
+func (f *fileServer) streamFile(request Request, name string) error {
+	file, err := f.root.Open(name)
+	if err != nil {
+		return request.TemporaryFailure("Could not read file")
+	}
+	defer file.Close()
+
+	w, err := request.Respond(MIMEType(name))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, file)
+
+	return err
+}
+
+func (f *fileServer) listDirectory(request Request, name string, requestPath string) error {
+	entries, err := fs.ReadDir(f.root, name)
+	if err != nil {
+		return request.TemporaryFailure("Could not read directory")
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	b := gemtext.NewBuilder()
+	b.AddHeader1Line("Index of " + requestPath)
+	if name != "." {
+		b.AddLinkLine("../", "..")
+	}
+
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if strings.HasPrefix(entryName, ".") {
+			continue
+		}
+
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		b.AddLinkLine((&url.URL{Path: entryName}).String(), entryName)
+	}
+
+	return request.GemtextFromBuilder(b)
+}
+
+// cleanFilePath converts a request path to a path valid for use with [fs.FS], which cannot escape the root
+func cleanFilePath(requestPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// MIMEType returns the MIME type of a file based on its extension.
+// Gemtext files (.gmi and .gemini) are text/gemini, and unknown extensions are application/octet-stream.
+func MIMEType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".gmi" || ext == ".gemini" {
+		return "text/gemini"
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+
+	return mimeType
+}
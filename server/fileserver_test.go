@@ -0,0 +1,55 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileServer(t *testing.T) {
+	root := fstest.MapFS{
+		"index.gmi":         {Data: []byte("# Home")},
+		"notes.txt":         {Data: []byte("Some notes")},
+		"images/cat.png":    {Data: []byte("PNG")},
+		"docs/index.gemini": {Data: []byte("# Docs")},
+	}
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"gemini://localhost/", "20 text/gemini\r\n# Home"},
+		{"gemini://localhost/notes.txt", "20 text/plain; charset=utf-8\r\nSome notes"},
+		{"gemini://localhost/images/cat.png", "20 image/png\r\nPNG"},
+		{"gemini://localhost/images", "31 gemini://localhost/images/\r\n"},
+		{"gemini://localhost/images/", "51 Not found\r\n"},
+		{"gemini://localhost/missing.gmi", "51 Not found\r\n"},
+		{"gemini://localhost/../../etc/passwd", "51 Not found\r\n"},
+	}
+
+	handler := FileServer(root)
+	for _, test := range tests {
+		got := serveTest(t, handler, test.uri)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
+
+func TestFileServer_DirectoryListing(t *testing.T) {
+	root := fstest.MapFS{
+		"images/cat.png":        {Data: []byte("PNG")},
+		"images/.hidden":        {Data: []byte("secret")},
+		"images/old/mouse.jpeg": {Data: []byte("JPEG")},
+	}
+
+	got := serveTest(t, FileServer(root, WithDirectoryListing()), "gemini://localhost/images/")
+	want := "20 text/gemini\r\n# Index of /images/\r\n=> ../ ..\r\n=> cat.png cat.png\r\n=> old/ old/"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if strings.Contains(got, ".hidden") {
+		t.Errorf("listing contains hidden file")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileStoreConfig configures the handlers created by [FileStore]
+type FileStoreConfig struct {
+	// Dir is the directory files are served from and uploaded to
+	Dir string
+	// Token is the secret uploads must provide as their token parameter. If empty, and Authorize is nil, uploads are
+	// refused unless AllowAnonymous is set
+	Token string
+	// Authorize decides whether an upload may proceed, instead of comparing its token to Token.
+	// It can respond to the request itself; otherwise, refused uploads receive status code 50
+	Authorize func(request TitanRequest) bool
+	// AllowAnonymous lets any client upload and delete files when neither Token nor Authorize is set
+	AllowAnonymous bool
+	// DirectoryListing generates gemtext listings for directories without an index.gmi file, as [WithDirectoryListing]
+	DirectoryListing bool
+}
+
+type fileStore struct {
+	config FileStoreConfig
+}
+
+// FileStore creates a pair of handlers for a wiki-style editable directory of files.
+//
+// The [Handler] serves files from the directory in the same way as [FileServer]. The [TitanHandler] accepts uploads
+// to the same paths, writing each file atomically so that clients never see a partial upload. An upload with a size
+// of zero deletes the file, as specified by Titan. The mime parameter of an upload must match the extension of the
+// file; if the path has no extension, one is added based on the MIME type. After a successful upload, the client is
+// redirected to the gemini:// URL of the updated file. Uploads are refused unless Token, Authorize or AllowAnonymous
+// is set.
+//
+// Both handlers should usually be registered on a dynamic route matching every path, such as "/*path".
+func FileStore(config FileStoreConfig) (Handler, TitanHandler) {
+	options := make([]FileServerOption, 0)
+	if config.DirectoryListing {
+		options = append(options, WithDirectoryListing())
+	}
+
+	f := &fileStore{config: config}
+
+	return FileServer(os.DirFS(config.Dir), options...), TitanRequestHandlerFunc(f.upload)
+}
+
+func (f *fileStore) upload(request TitanRequest) {
+	if !f.authorize(request) {
+		if !request.terminated() {
+			request.logWriteError(request.Error(StatusPermanentFailure, "Invalid token"))
+		}
+		return
+	}
+
+	requestPath := request.Path()
+	name := cleanFilePath(requestPath)
+	if name == "." || strings.HasSuffix(requestPath, "/") {
+		request.logWriteError(request.Error(StatusBadRequest, "Cannot upload to a directory"))
+		return
+	}
+
+	if request.Size == 0 {
+		f.delete(request, name)
+		return
+	}
+
+	name, err := nameForMIMEType(name, request.MIMEType)
+	if err != nil {
+		request.logWriteError(request.Error(StatusBadRequest, err.Error()))
+		return
+	}
+
+	filePath := filepath.Join(f.config.Dir, filepath.FromSlash(name))
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		request.logWriteError(request.Error(StatusBadRequest, "Cannot upload to a directory"))
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err == nil {
+		err = writeFileAtomic(filePath, request.Body, request.Size, 0o644)
+	}
+
+	if err != nil {
+		request.Logger().Error("Could not write file", "file", filePath, "error", err)
+		request.logWriteError(request.TemporaryFailure("Could not write file"))
+		return
+	}
+
+	target := url.URL{Scheme: "gemini", Host: request.URI.Host, Path: request.MountPoint() + "/" + name}
+	request.logWriteError(request.Redirect(target.String()))
+}
+
+func (f *fileStore) delete(request TitanRequest, name string) {
+	filePath := filepath.Join(f.config.Dir, filepath.FromSlash(name))
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		request.logWriteError(request.NotFound("Not found"))
+		return
+	}
+
+	if err == nil && info.IsDir() {
+		err = errors.New("cannot delete a directory")
+	}
+	if err == nil {
+		err = os.Remove(filePath)
+	}
+
+	if err != nil {
+		request.Logger().Error("Could not delete file", "file", filePath, "error", err)
+		request.logWriteError(request.TemporaryFailure("Could not delete file"))
+		return
+	}
+
+	dir := request.MountPoint() + "/"
+	if parent := path.Dir(name); parent != "." {
+		dir += parent + "/"
+	}
+
+	target := url.URL{Scheme: "gemini", Host: request.URI.Host, Path: dir}
+	request.logWriteError(request.Redirect(target.String()))
+}
+
+func (f *fileStore) authorize(request TitanRequest) bool {
+	if f.config.Authorize != nil {
+		return f.config.Authorize(request)
+	}
+
+	if f.config.Token == "" {
+		return f.config.AllowAnonymous
+	}
+
+	return subtle.ConstantTimeCompare([]byte(request.Token), []byte(f.config.Token)) == 1
+}
+
+// nameForMIMEType checks that the extension of name matches mimeType, adding an extension if name has none
+func nameForMIMEType(name string, mimeType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", errors.New("invalid MIME type")
+	}
+
+	if path.Ext(name) == "" {
+		if mediaType == "text/gemini" {
+			return name + ".gmi", nil
+		}
+
+		extensions, err := mime.ExtensionsByType(mediaType)
+		if err != nil || len(extensions) == 0 {
+			return "", errors.New("unknown MIME type " + mediaType)
+		}
+
+		return name + extensions[0], nil
+	}
+
+	fileType, _, err := mime.ParseMediaType(MIMEType(name))
+	if err != nil || fileType != mediaType {
+		return "", errors.New("MIME type does not match file extension")
+	}
+
+	return name, nil
+}
+
+// writeFileAtomic writes the contents of r to a temporary file in the same directory as path, then renames it to path.
+// If size is not negative, exactly size bytes must be read from r, otherwise path is left unchanged.
+func writeFileAtomic(path string, r io.Reader, size int64, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploadTest calls handler with a Titan upload of body to uri, returning the raw response written to the connection
+func uploadTest(t *testing.T, handler TitanHandler, uri string, body string, mimeType string, token string) string {
+	t.Helper()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, conn := net.Pipe()
+	go func() {
+		request := TitanRequest{
+			Request:  newRequest(*u, conn),
+			Token:    token,
+			MIMEType: mimeType,
+			Size:     int64(len(body)),
+			Body:     strings.NewReader(body),
+		}
+		handler.ServeTitan(request.w, &request)
+		conn.Close()
+	}()
+
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(response)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	serve, upload := FileStore(FileStoreConfig{Dir: dir, Token: "secret"})
+
+	got := uploadTest(t, upload, "titan://localhost/notes/today;size=7;mime=text/gemini;token=secret", "# Notes", "text/gemini", "secret")
+	if got != "30 gemini://localhost/notes/today.gmi\r\n" {
+		t.Fatalf("upload: got %q", got)
+	}
+
+	got = serveTest(t, serve, "gemini://localhost/notes/today.gmi")
+	if got != "20 text/gemini\r\n# Notes" {
+		t.Errorf("serve: got %q", got)
+	}
+
+	got = uploadTest(t, upload, "titan://localhost/notes/today.gmi;size=3", "Bad", "text/gemini", "wrong")
+	if got != "50 Invalid token\r\n" {
+		t.Errorf("upload with wrong token: got %q", got)
+	}
+
+	got = uploadTest(t, upload, "titan://localhost/notes/today.gmi;size=3", "PNG", "image/png", "secret")
+	if got != "59 MIME type does not match file extension\r\n" {
+		t.Errorf("upload with wrong MIME type: got %q", got)
+	}
+
+	got = uploadTest(t, upload, "titan://localhost/../escape.gmi;size=3", "Bad", "text/gemini", "secret")
+	if got != "30 gemini://localhost/escape.gmi\r\n" {
+		t.Errorf("upload outside store directory: got %q", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.gmi")); err == nil {
+		t.Errorf("upload escaped the store directory")
+	}
+
+	got = uploadTest(t, upload, "titan://localhost/notes/today.gmi;size=0", "", "text/gemini", "secret")
+	if got != "30 gemini://localhost/notes/\r\n" {
+		t.Errorf("delete: got %q", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "notes", "today.gmi")); err == nil {
+		t.Errorf("file was not deleted")
+	}
+}
+
+func TestFileStore_Authorize(t *testing.T) {
+	_, upload := FileStore(FileStoreConfig{
+		Dir: t.TempDir(),
+		Authorize: func(request TitanRequest) bool {
+			return request.Token == "letmein"
+		},
+	})
+
+	got := uploadTest(t, upload, "titan://localhost/page.txt", "Hi", "text/plain", "letmein")
+	if got != "30 gemini://localhost/page.txt\r\n" {
+		t.Errorf("authorised upload: got %q", got)
+	}
+
+	got = uploadTest(t, upload, "titan://localhost/page.txt", "Hi", "text/plain", "")
+	if got != "50 Invalid token\r\n" {
+		t.Errorf("unauthorised upload: got %q", got)
+	}
+}
+
+func TestFileStore_anonymous(t *testing.T) {
+	dir := t.TempDir()
+
+	_, upload := FileStore(FileStoreConfig{Dir: dir})
+	got := uploadTest(t, upload, "titan://localhost/page.txt", "Hi", "text/plain", "")
+	if got != "50 Invalid token\r\n" {
+		t.Errorf("upload without credentials configured: got %q", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "page.txt")); err == nil {
+		t.Errorf("file was written without credentials configured")
+	}
+
+	_, upload = FileStore(FileStoreConfig{Dir: dir, AllowAnonymous: true})
+	got = uploadTest(t, upload, "titan://localhost/page.txt", "Hi", "text/plain", "")
+	if got != "30 gemini://localhost/page.txt\r\n" {
+		t.Errorf("anonymous upload: got %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+// A ResponseWriter is used by a [Handler] to write the response to a [Request].
+type ResponseWriter interface {
+	// WriteHeader writes the response header, made up of a status code and meta, such as the MIME type of a
+	// successful response or an error message. It returns [ErrAlreadyResponded] if a header has already been written.
+	WriteHeader(code int, meta string) error
+	// Write writes part of the response body. If no header has been written, a success header with the MIME type
+	// "text/gemini" is written first.
+	Write(p []byte) (int, error)
+}
+
+// A Handler responds to a Gemini [Request].
+//
+// ServeGemini should write a response header and optionally a body to the [ResponseWriter], then return. Handlers
+// are registered with [Server.Handle], or [Server.RegisterHandler] for functions taking a Request by value.
+type Handler interface {
+	ServeGemini(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts an ordinary function to a [Handler]
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeGemini calls f(w, r). If r has no [ResponseWriter], such as a zero Request created in a test, f receives a
+// copy of r whose response methods write to w.
+func (f HandlerFunc) ServeGemini(w ResponseWriter, r *Request) {
+	if r.w == nil {
+		request := *r
+		request.w = w
+		r = &request
+	}
+
+	f(w, r)
+}
+
+// RequestHandlerFunc adapts a function responding using the methods of a [Request], as registered with
+// [Server.RegisterHandler], to a [Handler]
+type RequestHandlerFunc func(request Request)
+
+// ServeGemini calls f with a copy of r which responds to w
+func (f RequestHandlerFunc) ServeGemini(w ResponseWriter, r *Request) {
+	request := *r
+	request.w = w
+	f(request)
+}
+
+// bindWriter wraps handler so that the response methods of the [Request] it receives write to the [ResponseWriter]
+// it receives, even if a [Middleware] replaced the ResponseWriter
+func bindWriter(handler Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		request := *r
+		request.w = w
+		handler.ServeGemini(w, &request)
+	})
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// recorder is a fake ResponseWriter recording the response written to it
+type recorder struct {
+	status int
+	meta   string
+	body   bytes.Buffer
+}
+
+func (w *recorder) WriteHeader(code int, meta string) error {
+	if w.status != 0 {
+		return ErrAlreadyResponded
+	}
+
+	w.status = code
+	w.meta = meta
+
+	return nil
+}
+
+func (w *recorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = StatusSuccess
+		w.meta = "text/gemini"
+	}
+
+	return w.body.Write(p)
+}
+
+// counter is a Handler with state
+type counter struct {
+	hits int
+}
+
+func (c *counter) ServeGemini(w ResponseWriter, r *Request) {
+	c.hits++
+	_, err := io.WriteString(w, strconv.Itoa(c.hits))
+	r.logWriteError(err)
+}
+
+func TestHandler_fakeResponseWriter(t *testing.T) {
+	c := &counter{}
+	for i := 0; i < 2; i++ {
+		c.ServeGemini(&recorder{}, &Request{})
+	}
+
+	w := &recorder{}
+	c.ServeGemini(w, &Request{})
+	if w.status != StatusSuccess || w.meta != "text/gemini" || w.body.String() != "3" {
+		t.Errorf("got %d %q %q", w.status, w.meta, w.body.String())
+	}
+
+	w = &recorder{}
+	RequestHandlerFunc(func(request Request) {
+		request.logWriteError(request.NotFound("Nothing here"))
+	}).ServeGemini(w, &Request{})
+	if w.status != StatusNotFound || w.meta != "Nothing here" {
+		t.Errorf("RequestHandlerFunc: got %d %q", w.status, w.meta)
+	}
+}
+
+// prefixWriter is a ResponseWriter prefixing the meta of every response
+type prefixWriter struct {
+	ResponseWriter
+}
+
+func (w prefixWriter) WriteHeader(code int, meta string) error {
+	return w.ResponseWriter.WriteHeader(code, "wrapped "+meta)
+}
+
+func TestChain_replacedWriter(t *testing.T) {
+	wrap := func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			next.ServeGemini(prefixWriter{w}, r)
+		})
+	}
+
+	handlers := map[string]Handler{
+		"HandlerFunc": HandlerFunc(func(w ResponseWriter, r *Request) {
+			r.logWriteError(r.Gemtext("Hello"))
+		}),
+		"RequestHandlerFunc": RequestHandlerFunc(func(request Request) {
+			request.logWriteError(request.Gemtext("Hello"))
+		}),
+	}
+
+	for name, handler := range handlers {
+		got := serveTest(t, chain(handler, []Middleware{wrap}), "gemini://localhost/")
+		if got != "20 wrapped text/gemini\r\nHello" {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+}
+
+func TestChainTitan(t *testing.T) {
+	handler := chainTitan(TitanHandlerFunc(func(w ResponseWriter, r *TitanRequest) {
+		body, err := r.ReadBody()
+		if err != nil {
+			t.Error(err)
+		}
+
+		r.logWriteError(r.Gemtext(r.Session().ID + " " + string(body)))
+	}), []Middleware{func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			r.session = NewSession("abc", time.Now().Add(time.Hour))
+			next.ServeGemini(w, r)
+		})
+	}})
+
+	got := uploadTest(t, handler, "titan://localhost/file;size=5", "Hello", "text/plain", "")
+	if got != "20 text/gemini\r\nabc Hello" {
+		t.Errorf("got %q", got)
+	}
+}
+
+type contextKey struct{}
+
+func TestRequest_WithContext(t *testing.T) {
+	identify := func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			next.ServeGemini(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, "alice")))
+		})
+	}
+
+	handler := chain(RequestHandlerFunc(func(request Request) {
+		name, _ := request.Context().Value(contextKey{}).(string)
+		request.logWriteError(request.Gemtext(name))
+	}), []Middleware{identify})
+
+	got := serveTest(t, handler, "gemini://localhost/")
+	if got != "20 text/gemini\r\nalice" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHandlerFunc_zeroRequest(t *testing.T) {
+	w := &recorder{}
+	HandlerFunc(func(w ResponseWriter, r *Request) {
+		r.logWriteError(r.Gemtext("Hello"))
+	}).ServeGemini(w, &Request{})
+
+	if w.status != StatusSuccess || w.body.String() != "Hello" {
+		t.Errorf("got %d %q %q", w.status, w.meta, w.body.String())
+	}
+
+	var request Request
+	if err := request.Gemtext("Hello"); err != ErrNoResponseWriter {
+		t.Errorf("Gemtext on a zero Request returned %v, want ErrNoResponseWriter", err)
+	}
+}
+
+func TestGatewayHandlers_zeroRequest(t *testing.T) {
+	u, _ := url.Parse("gemini://localhost/script?query")
+
+	w := &recorder{}
+	script := writeScript(t, `printf '20 text/plain\r\n%s' "$QUERY_STRING"`)
+	CGIHandler(CGIConfig{Path: script}).ServeGemini(w, &Request{URI: *u})
+	if w.status != StatusSuccess || w.body.String() != "query" {
+		t.Errorf("CGI: got %d %q %q", w.status, w.meta, w.body.String())
+	}
+
+	w = &recorder{}
+	SCGIHandler(SCGIConfig{Address: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond}).ServeGemini(w, &Request{URI: *u})
+	if w.status != StatusProxyError {
+		t.Errorf("SCGI: got %d %q", w.status, w.meta)
+	}
+}
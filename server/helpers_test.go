@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// serveTest calls handler with a request for uri, returning the raw response written to the connection
+func serveTest(t *testing.T, handler Handler, uri string) string {
+	t.Helper()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, conn := net.Pipe()
+	go func() {
+		request := newRequest(*u, conn)
+		handler.ServeGemini(request.w, &request)
+		conn.Close()
+	}()
+
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(response)
+}
+
+// serveTLSTest calls handler with a request for uri made over TLS, with clientCert as the client certificate if it is
+// not nil, returning the raw response written to the connection
+func serveTLSTest(t *testing.T, handler Handler, uri string, clientCert *tls.Certificate) string {
+	t.Helper()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCert := newTestCertificate(t, "localhost", false, nil)
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		clientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	clientConn, serverConn := net.Pipe()
+	client := tls.Client(clientConn, clientConfig)
+	conn := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{*serverCert},
+		ClientAuth:   tls.RequestClientCert,
+	})
+
+	go func() {
+		defer conn.Close()
+
+		err := conn.Handshake()
+		if err != nil {
+			t.Errorf("TLS handshake failed: %v", err)
+			return
+		}
+
+		request := newRequest(*u, conn)
+		handler.ServeGemini(request.w, &request)
+	}()
+
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(response)
+}
+
+// newTestCertificate creates a certificate with the given common name, signed by parent, or self-signed if parent is nil
+func newTestCertificate(t *testing.T, commonName string, isCA bool, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+
+	return signTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}, parent)
+}
+
+// signTestCertificate creates a certificate from template with a new key, signed by parent, or self-signed if parent
+// is nil
+func signTestCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signerCert, signerKey := template, any(key)
+	if parent != nil {
+		signerCert, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of the handler latency histograms
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SizeBuckets are the upper bounds in bytes of the buckets of the response and upload size histograms
+var SizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+// Metrics collects statistics about the connections and requests served by a [Server]. It is safe for concurrent use.
+//
+// Requests are counted by protocol ("gemini" or "titan"), route and status class, such as "2x" for status codes
+// 20-29. The route is the pattern returned by [Request.Route]; requests which could not be routed have an empty route,
+// and requests without a valid URL also have an empty protocol. Requests whose handler did not respond have the status
+// class "none".
+//
+// Metrics implements [http.Handler], serving the metrics in the Prometheus text exposition format.
+type Metrics struct {
+	connections         atomic.Uint64
+	activeConnections   atomic.Int64
+	rejectedConnections atomic.Uint64
+	handshakeFailures   atomic.Uint64
+
+	mu           sync.Mutex
+	requests     map[requestLabels]uint64
+	responseSize histogram
+	uploadSize   histogram
+	latency      map[routeLabels]*histogram
+}
+
+// requestLabels identifies a counter of requests
+type requestLabels struct {
+	protocol    string
+	route       string
+	statusClass string
+}
+
+// routeLabels identifies a latency histogram
+type routeLabels struct {
+	protocol string
+	route    string
+}
+
+// histogram counts observations in buckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// MetricsSnapshot contains the values of [Metrics] at a point in time
+type MetricsSnapshot struct {
+	// Connections is the number of connections accepted
+	Connections uint64
+	// ActiveConnections is the number of connections currently open
+	ActiveConnections int64
+	// RejectedConnections is the number of connections refused with status code 41 because MaxConnections was reached
+	RejectedConnections uint64
+	// HandshakeFailures is the number of connections whose TLS handshake failed
+	HandshakeFailures uint64
+	// Requests contains the number of requests by protocol, route and status class
+	Requests []RequestCount
+	// ResponseSize is the distribution of the response body sizes in bytes
+	ResponseSize HistogramSnapshot
+	// UploadSize is the distribution of the sizes in bytes of Titan uploads passed to a handler
+	UploadSize HistogramSnapshot
+	// Latency contains the distribution of the handler durations in seconds by protocol and route
+	Latency []RouteLatency
+}
+
+// RequestCount is the number of requests with the same protocol, route and status class
+type RequestCount struct {
+	Protocol    string
+	Route       string
+	StatusClass string
+	Count       uint64
+}
+
+// RouteLatency is the distribution of the handler durations of a route
+type RouteLatency struct {
+	Protocol string
+	Route    string
+	HistogramSnapshot
+}
+
+// HistogramSnapshot contains the values of a histogram at a point in time
+type HistogramSnapshot struct {
+	// Buckets are the upper bounds of the buckets
+	Buckets []float64
+	// Counts contains the number of observations less than or equal to the upper bound of each bucket
+	Counts []uint64
+	// Count is the total number of observations
+	Count uint64
+	// Sum is the sum of all observations
+	Sum float64
+}
+
+// statusClass returns the class of a status code, such as "2x" for 20
+func statusClass(code int) string {
+	if code < 10 || code > 69 {
+		return "none"
+	}
+
+	return strconv.Itoa(code/10) + "x"
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) snapshot(buckets []float64) HistogramSnapshot {
+	counts := make([]uint64, len(buckets))
+	copy(counts, h.counts)
+
+	return HistogramSnapshot{Buckets: buckets, Counts: counts, Count: h.count, Sum: h.sum}
+}
+
+func (m *Metrics) connectionOpened() {
+	m.connections.Add(1)
+	m.activeConnections.Add(1)
+}
+
+func (m *Metrics) connectionClosed() {
+	m.activeConnections.Add(-1)
+}
+
+func (m *Metrics) connectionRejected() {
+	m.connections.Add(1)
+	m.rejectedConnections.Add(1)
+}
+
+func (m *Metrics) handshakeFailed() {
+	m.handshakeFailures.Add(1)
+}
+
+// observeRequest records a request which was not passed to a handler
+func (m *Metrics) observeRequest(protocol string, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.countRequest(protocol, "", status)
+}
+
+// observeHandled records a request which was passed to a handler
+func (m *Metrics) observeHandled(protocol string, request *Request, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	route := request.Route()
+	m.countRequest(protocol, route, request.Status())
+	m.responseSize.observe(SizeBuckets, float64(request.BytesWritten()))
+
+	if m.latency == nil {
+		m.latency = make(map[routeLabels]*histogram)
+	}
+
+	labels := routeLabels{protocol: protocol, route: route}
+	h, ok := m.latency[labels]
+	if !ok {
+		h = &histogram{}
+		m.latency[labels] = h
+	}
+	h.observe(LatencyBuckets, duration.Seconds())
+}
+
+// observeUpload records the size of a Titan upload passed to a handler
+func (m *Metrics) observeUpload(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uploadSize.observe(SizeBuckets, float64(size))
+}
+
+// countRequest increments the counter of requests. m.mu must be held
+func (m *Metrics) countRequest(protocol string, route string, status int) {
+	if m.requests == nil {
+		m.requests = make(map[requestLabels]uint64)
+	}
+
+	m.requests[requestLabels{protocol: protocol, route: route, statusClass: statusClass(status)}]++
+}
+
+// Snapshot returns the current values of the metrics, with requests and latencies sorted by protocol and route
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Connections:         m.connections.Load(),
+		ActiveConnections:   m.activeConnections.Load(),
+		RejectedConnections: m.rejectedConnections.Load(),
+		HandshakeFailures:   m.handshakeFailures.Load(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for labels, count := range m.requests {
+		snapshot.Requests = append(snapshot.Requests, RequestCount{
+			Protocol:    labels.protocol,
+			Route:       labels.route,
+			StatusClass: labels.statusClass,
+			Count:       count,
+		})
+	}
+	sort.Slice(snapshot.Requests, func(i, j int) bool {
+		a, b := snapshot.Requests[i], snapshot.Requests[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		return a.StatusClass < b.StatusClass
+	})
+
+	for labels, h := range m.latency {
+		snapshot.Latency = append(snapshot.Latency, RouteLatency{
+			Protocol:          labels.protocol,
+			Route:             labels.route,
+			HistogramSnapshot: h.snapshot(LatencyBuckets),
+		})
+	}
+	sort.Slice(snapshot.Latency, func(i, j int) bool {
+		a, b := snapshot.Latency[i], snapshot.Latency[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Route < b.Route
+	})
+
+	snapshot.ResponseSize = m.responseSize.snapshot(SizeBuckets)
+	snapshot.UploadSize = m.uploadSize.snapshot(SizeBuckets)
+
+	return snapshot
+}
+
+// WritePrometheus writes the current values of the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	b := bufio.NewWriter(w)
+
+	writeMetricHeader(b, "gomini_connections_total", "counter", "Connections accepted.")
+	fmt.Fprintf(b, "gomini_connections_total %d\n", snapshot.Connections)
+	writeMetricHeader(b, "gomini_active_connections", "gauge", "Connections currently open.")
+	fmt.Fprintf(b, "gomini_active_connections %d\n", snapshot.ActiveConnections)
+	writeMetricHeader(b, "gomini_rejected_connections_total", "counter",
+		"Connections refused because the connection limit was reached.")
+	fmt.Fprintf(b, "gomini_rejected_connections_total %d\n", snapshot.RejectedConnections)
+	writeMetricHeader(b, "gomini_tls_handshake_failures_total", "counter", "Connections whose TLS handshake failed.")
+	fmt.Fprintf(b, "gomini_tls_handshake_failures_total %d\n", snapshot.HandshakeFailures)
+
+	writeMetricHeader(b, "gomini_requests_total", "counter", "Requests by protocol, route and status class.")
+	for _, count := range snapshot.Requests {
+		fmt.Fprintf(b, "gomini_requests_total{%s} %d\n", formatLabels(
+			"protocol", count.Protocol,
+			"route", count.Route,
+			"status_class", count.StatusClass,
+		), count.Count)
+	}
+
+	writeMetricHeader(b, "gomini_response_size_bytes", "histogram", "Sizes of response bodies.")
+	writeHistogram(b, "gomini_response_size_bytes", "", snapshot.ResponseSize)
+	writeMetricHeader(b, "gomini_titan_upload_size_bytes", "histogram", "Sizes of Titan uploads.")
+	writeHistogram(b, "gomini_titan_upload_size_bytes", "", snapshot.UploadSize)
+
+	writeMetricHeader(b, "gomini_handler_duration_seconds", "histogram", "Durations of handlers by protocol and route.")
+	for _, latency := range snapshot.Latency {
+		labels := formatLabels("protocol", latency.Protocol, "route", latency.Route)
+		writeHistogram(b, "gomini_handler_duration_seconds", labels, latency.HistogramSnapshot)
+	}
+
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeHistogram writes the samples of a histogram, with labels added to the labels of each sample
+func writeHistogram(w io.Writer, name string, labels string, h HistogramSnapshot) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	for i, bound := range h.Buckets {
+		var count uint64
+		if i < len(h.Counts) {
+			count = h.Counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, prefix, strconv.FormatFloat(bound, 'g', -1, 64), count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.Count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats pairs of label names and values as a Prometheus label set, without braces
+func formatLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+
+	return strings.Join(labels, ",")
+}
+
+// Metrics returns the [Metrics] of the [Server]
+func (s *Server) Metrics() *Metrics {
+	return &s.metrics
+}
+
+// ListenAndServeMetrics serves the [Metrics] of the [Server] in the Prometheus text exposition format over plain HTTP
+// on addr, at every path. As the metrics are not protected, addr should usually be a loopback address, such as
+// "localhost:9100".
+// It always returns a non-nil error; after [Server.Close] or [Server.Shutdown], the returned error is [ErrServerClosed].
+func (s *Server) ListenAndServeMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Handler: &s.metrics, ReadHeaderTimeout: 10 * time.Second}
+
+	s.mu.Lock()
+	if s.inShutdown.Load() {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.metricsServers = append(s.metricsServers, httpServer)
+	s.mu.Unlock()
+
+	err = httpServer.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ErrServerClosed
+	}
+
+	return err
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_WritePrometheus(t *testing.T) {
+	var m Metrics
+	m.connectionOpened()
+	m.handshakeFailed()
+	m.observeRequest("", StatusBadRequest)
+
+	request := Request{route: "/say/\"hi\"", w: &response{status: StatusSuccess, written: 2000}}
+	m.observeHandled("gemini", &request, 30*time.Millisecond)
+
+	var buf bytes.Buffer
+	err := m.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE gomini_connections_total counter",
+		"gomini_connections_total 1",
+		"gomini_active_connections 1",
+		"gomini_tls_handshake_failures_total 1",
+		`gomini_requests_total{protocol="",route="",status_class="5x"} 1`,
+		`gomini_requests_total{protocol="gemini",route="/say/\"hi\"",status_class="2x"} 1`,
+		`gomini_response_size_bytes_bucket{le="1024"} 0`,
+		`gomini_response_size_bytes_bucket{le="4096"} 1`,
+		`gomini_response_size_bytes_bucket{le="+Inf"} 1`,
+		"gomini_response_size_bytes_sum 2000",
+		`gomini_handler_duration_seconds_bucket{protocol="gemini",route="/say/\"hi\"",le="0.025"} 0`,
+		`gomini_handler_duration_seconds_bucket{protocol="gemini",route="/say/\"hi\"",le="0.05"} 1`,
+		`gomini_handler_duration_seconds_count{protocol="gemini",route="/say/\"hi\""} 1`,
+		"gomini_titan_upload_size_bytes_count 0",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Metrics do not contain %s:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, want := range map[int]string{0: "none", 10: "1x", 20: "2x", 59: "5x", 62: "6x", 70: "none"} {
+		if got := statusClass(code); got != want {
+			t.Errorf("statusClass(%d) = %s, want %s", code, got, want)
+		}
+	}
+}
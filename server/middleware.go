@@ -0,0 +1,119 @@
+package server
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// A Middleware wraps a [Handler] to run logic before and/or after it.
+// Middleware is applied to both Gemini and Titan routes; for a [TitanRequest], the middleware is given the embedded [Request].
+// A Middleware is called once for each route, when the route is first served, rather than for every request, so it can
+// set up state shared by the requests of the route. It is called again if [Server.Use] or [Router.Use] adds middleware.
+type Middleware func(next Handler) Handler
+
+// Use registers middleware to be applied to every route of the [Server], including routes registered before Use was called.
+// Middleware is applied in registration order, so the first middleware registered is the outermost.
+func (s *Server) Use(middleware ...Middleware) {
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
+
+	s.middleware = append(s.middleware, middleware...)
+	s.resetChains()
+}
+
+// chain wraps handler in middleware, with the first middleware being the outermost
+func chain(handler Handler, middleware []Middleware) Handler {
+	handler = bindWriter(handler)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = bindWriter(middleware[i](handler))
+	}
+
+	return handler
+}
+
+// chainTitan wraps a [TitanHandler] in middleware, with the first middleware being the outermost
+func chainTitan(handler TitanHandler, middleware []Middleware) TitanHandler {
+	handler = bindTitanWriter(handler)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = titanMiddleware(middleware[i], handler)
+	}
+
+	return handler
+}
+
+// titanMiddleware adapts a [Middleware] to wrap a [TitanHandler].
+// Changes the middleware makes to the [Request] are passed on to the TitanHandler.
+func titanMiddleware(m Middleware, next TitanHandler) TitanHandler {
+	handler := m(HandlerFunc(func(w ResponseWriter, r *Request) {
+		var titanRequest TitanRequest
+		if r.titan != nil {
+			titanRequest = *r.titan
+		}
+		titanRequest.Request = *r
+		next.ServeTitan(w, &titanRequest)
+	}))
+
+	return TitanHandlerFunc(func(w ResponseWriter, r *TitanRequest) {
+		request := *r
+		request.w = w
+		request.titan = r
+		handler.ServeGemini(w, &request.Request)
+	})
+}
+
+// Recover creates a [Middleware] that recovers from panics in handlers.
+// The panic is logged along with a stack trace, and if the handler has not yet responded, the client receives
+// status code 40. A [Server] recovers from panics in handlers by itself; Recover is useful to let outer middleware,
+// such as [Logger], observe the response, but panics it recovers from are not passed to OnPanic.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					request.Logger().Error("Handler panicked", "panic", err, "stack", string(debug.Stack()))
+					if request.Status() == 0 {
+						request.logWriteError(request.TemporaryFailure("Internal server error"))
+					}
+				}
+			}()
+
+			next.ServeGemini(w, request)
+		})
+	}
+}
+
+// Logger creates a [Middleware] that logs every request to [Request.Logger] along with its response status, the
+// number of bytes written and the time taken to handle it. Requests whose handler panics are logged too, with status 0
+// unless [Recover] is applied inside Logger. A [Server] already logs every request it answers; Logger is useful to log
+// the requests of specific routes, or requests handled outside of a Server.
+func Logger() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			start := time.Now()
+			defer func() {
+				request.Logger().Info("Request handled",
+					"status", request.Status(),
+					"bytes", request.BytesWritten(),
+					"duration", time.Since(start),
+				)
+			}()
+
+			next.ServeGemini(w, request)
+		})
+	}
+}
+
+// RequireCertificate creates a [Middleware] that responds with status code 60 and message if the client did not
+// provide a certificate. Otherwise, the request is passed on to the next handler.
+func RequireCertificate(message string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			if len(request.GetClientCertificates()) == 0 {
+				request.logWriteError(request.CertificateRequired(message))
+				return
+			}
+
+			next.ServeGemini(w, request)
+		})
+	}
+}
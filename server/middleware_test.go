@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// traceMiddleware creates a [Middleware] appending name to trace before and after calling the next handler
+func traceMiddleware(trace *[]string, name string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			*trace = append(*trace, name)
+			next.ServeGemini(w, request)
+			*trace = append(*trace, "/"+name)
+		})
+	}
+}
+
+func TestServer_Use_order(t *testing.T) {
+	var trace []string
+
+	s := New()
+	s.Use(traceMiddleware(&trace, "first"), traceMiddleware(&trace, "second"))
+	s.RegisterHandler("/route", func(request Request) {
+		trace = append(trace, "handler")
+		request.Gemtext("ok")
+	}, traceMiddleware(&trace, "route"))
+	s.RegisterHandler("/other", func(request Request) {
+		trace = append(trace, "other")
+		request.Gemtext("ok")
+	})
+	// Middleware registered after a route still applies to it
+	s.Use(traceMiddleware(&trace, "third"))
+
+	handler, err := s.resolve("localhost", "/route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTest(t, handler, "gemini://localhost/route")
+
+	want := "first second third route handler /route /third /second /first"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("got trace %q, want %q", got, want)
+	}
+
+	trace = nil
+	handler, err = s.resolve("localhost", "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTest(t, handler, "gemini://localhost/other")
+
+	want = "first second third other /third /second /first"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("got trace %q, want %q", got, want)
+	}
+}
+
+func TestServer_Use_builtOnce(t *testing.T) {
+	var mu sync.Mutex
+	built := map[string]int{}
+	counting := func(name string) Middleware {
+		return func(next Handler) Handler {
+			mu.Lock()
+			defer mu.Unlock()
+
+			built[name]++
+			return next
+		}
+	}
+	count := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return built[name]
+	}
+
+	s := New()
+	s.Use(counting("server"))
+	wiki := NewRouter()
+	wiki.Use(counting("router"))
+	wiki.RegisterHandler("/:page", func(request Request) {
+		request.Gemtext(request.Params["page"])
+	}, counting("route"))
+	wiki.RegisterTitanHandler("/:page", func(request TitanRequest) {
+		request.Gemtext(request.Params["page"])
+	})
+	s.Mount("/wiki", wiki)
+
+	addr, _ := listenTest(t, s)
+	for i := 0; i < 5; i++ {
+		io.ReadAll(sendRequest(t, addr, "gemini://localhost/wiki/page"+strconv.Itoa(i)))
+		io.ReadAll(sendRequest(t, addr, "titan://localhost/wiki/page;size=0"))
+	}
+
+	// The Gemini and Titan routes are each built once
+	want := map[string]int{"server": 2, "router": 2, "route": 1}
+	for name, n := range want {
+		if count(name) != n {
+			t.Errorf("%s middleware built %d times, want %d", name, count(name), n)
+		}
+	}
+
+	// Adding middleware rebuilds the routes with it
+	s.Use(counting("late"))
+	got, _ := io.ReadAll(sendRequest(t, addr, "gemini://localhost/wiki/again"))
+	if string(got) != "20 text/gemini\r\nagain" || count("late") != 1 || count("server") != 3 {
+		t.Errorf("after Use: got %q, server built %d times, late built %d times", got, count("server"), count("late"))
+	}
+}
+
+func TestServer_Use_constructorPanic(t *testing.T) {
+	s := New()
+	s.Use(func(next Handler) Handler {
+		panic("cannot build middleware")
+	})
+	s.RegisterHandler("/", func(request Request) {
+		request.Gemtext("unreachable")
+	})
+
+	addr, _ := listenTest(t, s)
+	for i := 0; i < 2; i++ {
+		got, _ := io.ReadAll(sendRequest(t, addr, "gemini://localhost/"))
+		if string(got) != "40 Temporary failure\r\n" {
+			t.Errorf("got %q, want %q", got, "40 Temporary failure\r\n")
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := Recover()(RequestHandlerFunc(func(request Request) {
+		panic("handler failed")
+	}))
+
+	got := serveTest(t, handler, "gemini://localhost/")
+	want := "40 Internal server error\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	handler := Logger()(RequestHandlerFunc(func(request Request) {
+		request.Gemtext("Hello")
+	}))
+	serveTest(t, HandlerFunc(func(w ResponseWriter, request *Request) {
+		request.logger = logger
+		handler.ServeGemini(w, request)
+	}), "gemini://localhost/")
+
+	for _, want := range []string{`msg="Request handled"`, "status=20", "bytes=5", "duration="} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %s: %q", want, buf.String())
+		}
+	}
+}
+
+func TestRequireCertificate(t *testing.T) {
+	handler := RequireCertificate("Certificate required")(RequestHandlerFunc(func(request Request) {
+		request.Gemtext("Secret")
+	}))
+
+	got := serveTest(t, handler, "gemini://localhost/")
+	want := "60 Certificate required\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got = serveTLSTest(t, handler, "gemini://localhost/", newTestCertificate(t, "client", false, nil))
+	want = "20 text/gemini\r\nSecret"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogger_panic(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	handler := Recover()(Logger()(RequestHandlerFunc(func(request Request) {
+		panic("handler failed")
+	})))
+	serveTest(t, HandlerFunc(func(w ResponseWriter, request *Request) {
+		request.logger = logger
+		handler.ServeGemini(w, request)
+	}), "gemini://localhost/")
+
+	if !strings.Contains(buf.String(), `msg="Request handled" status=0`) {
+		t.Errorf("panicking request was not logged: %q", buf.String())
+	}
+}
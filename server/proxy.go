@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nailuj29/gomini/client"
+)
+
+// forwardedFingerprintPrefix prefixes the fingerprint of the original client in the URI SAN of identity certificates
+const forwardedFingerprintPrefix = "gomini:fingerprint:"
+
+// ReverseProxyConfig configures a [Handler] created by [ReverseProxy]
+type ReverseProxyConfig struct {
+	// Upstream is the URL requests are forwarded to, such as "gemini://internal:1966/wiki".
+	// The path of each request, relative to the mount point of the [Router] serving it, is appended to the path of
+	// Upstream
+	Upstream string
+	// StripPrefix is removed from the start of the path of each request before it is forwarded. When the proxy is
+	// served by a mounted [Router], the path is relative to the mount point
+	StripPrefix string
+	// TLSConfig is used to connect to the upstream server. If nil, the certificate of the upstream server is not
+	// verified, as Gemini servers commonly use self-signed certificates
+	TLSConfig *tls.Config
+	// IdentityCA is a CA certificate, including its private key, used to forward the identity of clients.
+	// If set, each client with a certificate is represented to the upstream server by a short-lived certificate
+	// issued by IdentityCA, which has the same subject as the client certificate and carries its [Fingerprint].
+	// The upstream server should only trust certificates issued by IdentityCA, and can retrieve the fingerprint of
+	// the original client with [ForwardedFingerprint].
+	IdentityCA *tls.Certificate
+	// DialTimeout is the maximum duration allowed for connecting to the upstream server, including the TLS handshake.
+	// Defaults to 5 seconds
+	DialTimeout time.Duration
+	// Timeout is the maximum duration allowed for the upstream server to respond, including streaming the response.
+	// Defaults to 30 seconds
+	Timeout time.Duration
+}
+
+type reverseProxy struct {
+	config     ReverseProxyConfig
+	upstream   *url.URL
+	caCert     *x509.Certificate
+	mu         sync.Mutex
+	identities map[string]*tls.Certificate
+}
+
+// ReverseProxy creates a [Handler] that forwards requests to an upstream Gemini server, and streams its response
+// back to the client. If the upstream server cannot be reached, does not respond in time, or responds with an invalid
+// header, the client receives status code 43. Requests to the upstream server are cancelled along with the context of
+// the [Request].
+// It returns an error if the configuration is invalid.
+func ReverseProxy(config ReverseProxyConfig) (Handler, error) {
+	upstream, err := url.Parse(config.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if upstream.Scheme != "gemini" || upstream.Host == "" {
+		return nil, errors.New("upstream must be a gemini:// URL with a host")
+	}
+
+	if config.TLSConfig == nil {
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	p := &reverseProxy{
+		config:     config,
+		upstream:   upstream,
+		identities: make(map[string]*tls.Certificate),
+	}
+
+	if config.IdentityCA != nil {
+		if len(config.IdentityCA.Certificate) == 0 {
+			return nil, errors.New("identity CA has no certificate")
+		}
+
+		p.caCert, err = x509.ParseCertificate(config.IdentityCA.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return RequestHandlerFunc(p.serve), nil
+}
+
+// ForwardedFingerprint returns the [Fingerprint] of the original client certificate carried by a certificate issued
+// by a [ReverseProxy] with an IdentityCA. It reports false if cert does not carry a fingerprint.
+//
+// The fingerprint can only be trusted if cert has been verified to be issued by the IdentityCA of the proxy.
+func ForwardedFingerprint(cert *x509.Certificate) (string, bool) {
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "urn" {
+			continue
+		}
+
+		fingerprint, ok := strings.CutPrefix(uri.Opaque, forwardedFingerprintPrefix)
+		if ok {
+			return fingerprint, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *reverseProxy) serve(request Request) {
+	tlsConfig := p.config.TLSConfig
+	certs := request.GetClientCertificates()
+	if p.caCert != nil && len(certs) > 0 {
+		identity, err := p.identity(certs[0])
+		if err != nil {
+			request.Logger().Error("Could not issue identity certificate", "error", err)
+			proxyError(request)
+			return
+		}
+
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.Certificates = []tls.Certificate{*identity}
+	}
+
+	uri := request.URI
+	uri.Path = request.Path()
+	ctx, cancel := context.WithTimeout(request.Context(), p.config.Timeout)
+	defer cancel()
+
+	upstream := client.Client{TLSConfig: tlsConfig, Logger: request.Logger(), DialTimeout: p.config.DialTimeout}
+	response, err := upstream.RequestStreamContext(ctx, p.target(uri).String())
+	if err != nil {
+		request.Logger().Error("Could not reach upstream", "upstream", p.upstream.Host, "error", err)
+		proxyError(request)
+		return
+	}
+	defer response.Body.Close()
+
+	err = request.writeHeader(response.StatusCode, response.MetaData)
+	if err != nil {
+		request.Logger().Error("Upstream responded with an invalid header", "upstream", p.upstream.Host, "error", err)
+		proxyError(request)
+		return
+	}
+
+	if response.StatusCode == StatusSuccess {
+		_, err = io.Copy(request.w, response.Body)
+		if err != nil {
+			request.logWriteError(err)
+		}
+	}
+}
+
+// target returns the upstream URL a request for uri is forwarded to
+func (p *reverseProxy) target(uri url.URL) *url.URL {
+	path := strings.TrimPrefix(uri.Path, p.config.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	target := *p.upstream
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawPath = ""
+	target.RawQuery = uri.RawQuery
+
+	return &target
+}
+
+// identity returns a certificate issued by the IdentityCA representing client, reusing previously issued certificates
+// until they are close to expiring
+func (p *reverseProxy) identity(client *x509.Certificate) (*tls.Certificate, error) {
+	fingerprint := Fingerprint(client)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	identity, ok := p.identities[fingerprint]
+	if ok && identity.Leaf.NotAfter.Sub(now) > 5*time.Minute {
+		return identity, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      client.Subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{{Scheme: "urn", Opaque: forwardedFingerprintPrefix + fingerprint}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, p.caCert, &key.PublicKey, p.config.IdentityCA.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.identities) >= 1024 {
+		for key, cached := range p.identities {
+			if cached.Leaf.NotAfter.Before(now) {
+				delete(p.identities, key)
+			}
+		}
+
+		if len(p.identities) >= 1024 {
+			clear(p.identities)
+		}
+	}
+
+	identity = &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	p.identities[fingerprint] = identity
+
+	return identity, nil
+}
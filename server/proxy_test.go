@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy_identity(t *testing.T) {
+	ca := newTestCertificate(t, "Proxy CA", true, nil)
+	clientCert := newTestCertificate(t, "alice", false, nil)
+
+	p := &reverseProxy{config: ReverseProxyConfig{IdentityCA: ca}, caCert: ca.Leaf, identities: make(map[string]*tls.Certificate)}
+	identity, err := p.identity(clientCert.Leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Leaf.Subject.CommonName != "alice" {
+		t.Errorf("identity has common name %q, want %q", identity.Leaf.Subject.CommonName, "alice")
+	}
+
+	fingerprint, ok := ForwardedFingerprint(identity.Leaf)
+	if !ok || fingerprint != Fingerprint(clientCert.Leaf) {
+		t.Errorf("got forwarded fingerprint %q, want %q", fingerprint, Fingerprint(clientCert.Leaf))
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	_, err = identity.Leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("identity not verified by CA: %v", err)
+	}
+
+	cached, err := p.identity(clientCert.Leaf)
+	if err != nil || cached != identity {
+		t.Errorf("identity certificate was not reused")
+	}
+
+	if _, ok := ForwardedFingerprint(clientCert.Leaf); ok {
+		t.Errorf("got forwarded fingerprint for a certificate without one")
+	}
+}
+
+func TestReverseProxy_target(t *testing.T) {
+	p := &reverseProxy{config: ReverseProxyConfig{StripPrefix: "/wiki"}}
+	p.upstream, _ = url.Parse("gemini://internal:1966/pages/")
+
+	uri, _ := url.Parse("gemini://example.org/wiki/Main%20Page?edit")
+	got := p.target(*uri).String()
+	want := "gemini://internal:1966/pages/Main%20Page?edit"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReverseProxy_invalidUpstream(t *testing.T) {
+	for _, upstream := range []string{"https://example.org/", "gemini:///path", ":"} {
+		_, err := ReverseProxy(ReverseProxyConfig{Upstream: upstream})
+		if err == nil {
+			t.Errorf("no error for upstream %q", upstream)
+		}
+	}
+}
+
+func TestReverseProxy_timeout(t *testing.T) {
+	serverCert := newTestCertificate(t, "localhost", false, nil)
+	tests := map[string]func(t *testing.T) net.Listener{
+		"no handshake": func(t *testing.T) net.Listener {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return l
+		},
+		"no response": func(t *testing.T) net.Listener {
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return l
+		},
+	}
+
+	for name, listen := range tests {
+		t.Run(name, func(t *testing.T) {
+			l := listen(t)
+			defer l.Close()
+
+			// Accept connections, complete the TLS handshake if there is one, and never respond
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+
+					if tlsConn, ok := conn.(*tls.Conn); ok {
+						tlsConn.Handshake()
+					}
+				}
+			}()
+
+			handler, err := ReverseProxy(ReverseProxyConfig{
+				Upstream:    "gemini://" + l.Addr().String() + "/",
+				DialTimeout: 100 * time.Millisecond,
+				Timeout:     200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			got := serveTest(t, handler, "gemini://localhost/page")
+			want := "43 Proxy error\r\n"
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("proxy took %v to give up", elapsed)
+			}
+		})
+	}
+}
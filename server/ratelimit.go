@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// A RateLimiter decides whether a client may make a request.
+// Implementations must be safe for concurrent use.
+type RateLimiter interface {
+	// Allow reports whether the client identified by key may make a request now.
+	// If not, it also returns how long the client should wait before trying again.
+	Allow(key string) (bool, time.Duration)
+}
+
+// A KeyFunc identifies the client making a [Request] for a [RateLimiter]
+type KeyFunc func(request Request) string
+
+// KeyByIP is a [KeyFunc] identifying clients by their IP address
+func KeyByIP(request Request) string {
+	addr := request.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// KeyByCertificate is a [KeyFunc] identifying clients by the [Fingerprint] of their certificate.
+// Clients without a certificate are identified by their IP address.
+func KeyByCertificate(request Request) string {
+	fingerprint := request.ClientFingerprint()
+	if fingerprint == "" {
+		return KeyByIP(request)
+	}
+
+	return fingerprint
+}
+
+// Fingerprint returns the hex encoded SHA-256 hash of a certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// RateLimit creates a [Middleware] that responds with status code 44 when limiter does not allow a request.
+// Clients are identified using key, or [KeyByIP] if key is nil.
+func RateLimit(limiter RateLimiter, key KeyFunc) Middleware {
+	if key == nil {
+		key = KeyByIP
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			ok, wait := limiter.Allow(key(*request))
+			if !ok {
+				request.logWriteError(request.SlowDown(int(math.Max(1, math.Ceil(wait.Seconds())))))
+				return
+			}
+
+			next.ServeGemini(w, request)
+		})
+	}
+}
+
+// SetRateLimiter sets the [RateLimiter] applied to every route of the [Server] that does not have its own limiter set
+// with [Server.SetRouteRateLimiter]. Clients are identified using key, or [KeyByIP] if key is nil.
+// Passing a nil limiter disables rate limiting.
+func (s *Server) SetRateLimiter(limiter RateLimiter, key KeyFunc) {
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
+
+	s.resetChains()
+	s.rateLimiter = limiter
+	s.rateLimitKey = key
+}
+
+// SetRouteRateLimiter overrides the [RateLimiter] for a route, as passed to [Server.Handle] or
+// [Server.HandleTitan]. Passing a nil limiter disables rate limiting for the route.
+func (s *Server) SetRouteRateLimiter(path string, limiter RateLimiter) {
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
+
+	s.resetChains()
+	if s.routeRateLimiters == nil {
+		s.routeRateLimiters = make(map[string]RateLimiter)
+	}
+	s.routeRateLimiters[path] = limiter
+}
+
+// rateLimit returns the middleware enforcing the rate limiter for the route registered at path, if any
+func (s *Server) rateLimit(path string) []Middleware {
+	limiter, ok := s.routeRateLimiters[path]
+	if !ok {
+		limiter = s.rateLimiter
+	}
+
+	if limiter == nil {
+		return nil
+	}
+
+	return []Middleware{RateLimit(limiter, s.rateLimitKey)}
+}
+
+// DefaultRate and DefaultBurst are the parameters used by [NewTokenBucket] when given non-positive values
+const (
+	DefaultRate  = 1
+	DefaultBurst = 10
+)
+
+// TokenBucket is a [RateLimiter] using the token bucket algorithm.
+// Each client has a bucket of tokens, which refills at a constant rate up to a maximum burst size.
+// Every request takes a token from the bucket, and is refused when the bucket is empty.
+type TokenBucket struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a [TokenBucket] allowing rate requests per second, with bursts of up to burst requests.
+// If rate or burst is not positive, [DefaultRate] or [DefaultBurst] is used instead.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow implements [RateLimiter]
+func (t *TokenBucket) Allow(key string) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[key] = b
+	}
+
+	b.tokens = math.Min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / t.rate * float64(time.Second))
+}
+
+// sweep removes buckets which would have refilled completely, at most once per minute
+func (t *TokenBucket) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	for key, b := range t.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*t.rate >= t.burst {
+			delete(t.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	limiter := NewTokenBucket(1, 3)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("client")
+		if !ok {
+			t.Fatalf("request %d refused within burst", i+1)
+		}
+	}
+
+	ok, wait := limiter.Allow("client")
+	if ok {
+		t.Fatalf("request allowed after burst was used up")
+	}
+
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("got wait %v, want between 0 and 1s", wait)
+	}
+
+	ok, _ = limiter.Allow("other client")
+	if !ok {
+		t.Errorf("request from another client refused")
+	}
+}
+
+func TestTokenBucket_Refill(t *testing.T) {
+	limiter := NewTokenBucket(100, 1)
+
+	ok, _ := limiter.Allow("client")
+	if !ok {
+		t.Fatalf("first request refused")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	ok, _ = limiter.Allow("client")
+	if !ok {
+		t.Errorf("request refused after bucket refilled")
+	}
+}
@@ -1,23 +1,181 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/nailuj29/gomini/gemtext"
+	"io"
+	"log/slog"
+	"net"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrAlreadyResponded is returned when attempting to respond to a [Request] that has already been terminated
+	ErrAlreadyResponded = errors.New("already responded")
+	// ErrMetaTooLong is returned when the meta of a response exceeds [MaxMetaLength] bytes
+	ErrMetaTooLong = errors.New("meta exceeds 1024 bytes")
+	// ErrInvalidMeta is returned when the meta of a response contains a line break
+	ErrInvalidMeta = errors.New("meta must not contain CR or LF")
+	// ErrInvalidStatus is returned when responding with a status code outside the range 10-69
+	ErrInvalidStatus = errors.New("invalid status code")
+	// ErrNoResponseWriter is returned when responding to a [Request] which has no [ResponseWriter], because it was not
+	// passed to a [Handler]
+	ErrNoResponseWriter = errors.New("request has no ResponseWriter")
 )
 
 // Request wraps a Gemini request.
+//
+// The response methods of a Request, such as [Request.Gemtext], write to the [ResponseWriter] the Request was passed
+// to the [Handler] with.
 type Request struct {
 	// URI contains a [url.URL] object corresponding to the URL of the request.
 	URI url.URL
 	// Params contains a map of URL params passed into the request. Nil if there are no params.
 	Params     map[string]string
-	conn       *tls.Conn
+	path       string
+	mountPoint string
+	route      string
+	conn       net.Conn
+	w          ResponseWriter
+	session    *Session
+	ctx        context.Context
+	logger     *slog.Logger
+	// titan is the Titan request this Request is embedded in, while it is passed through middleware
+	titan *TitanRequest
+}
+
+// response is the [ResponseWriter] writing to the connection of a [Request].
+// It is shared between copies of the Request, so that middleware can observe what a [Handler] wrote.
+type response struct {
+	mu         sync.Mutex
+	conn       net.Conn
 	terminated bool
+	status     int
+	meta       string
+	written    int64
+}
+
+func newRequest(uri url.URL, conn net.Conn) Request {
+	path := uri.Path
+	if uri.Scheme == "titan" {
+		path, _, _ = strings.Cut(path, ";")
+	}
+
+	return Request{
+		URI:  uri,
+		path: path,
+		conn: conn,
+		w:    &response{conn: conn},
+	}
+}
+
+// WriteHeader validates and writes a response header
+func (w *response) WriteHeader(code int, meta string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writeHeader(code, meta)
+}
+
+func (w *response) writeHeader(code int, meta string) error {
+	if w.terminated {
+		return ErrAlreadyResponded
+	}
+
+	if code < 10 || code > 69 {
+		return ErrInvalidStatus
+	}
+
+	if len(meta) > MaxMetaLength {
+		return ErrMetaTooLong
+	}
+
+	if strings.ContainsAny(meta, "\r\n") {
+		return ErrInvalidMeta
+	}
+
+	_, err := w.conn.Write([]byte(fmt.Sprintf("%d %s\r\n", code, meta)))
+	if err != nil {
+		return err
+	}
+
+	w.terminated = true
+	w.status = code
+	w.meta = meta
+
+	return nil
+}
+
+// Write writes part of the response body, keeping track of the number of bytes written.
+// If no header has been written, a success header with the MIME type "text/gemini" is written first.
+func (w *response) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.terminated {
+		err := w.writeHeader(StatusSuccess, "text/gemini")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.conn.Write(p)
+	w.written += int64(n)
+
+	return n, err
+}
+
+// Status returns the status code of the response, or 0 if no header has been written
+func (w *response) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.status
+}
+
+// Meta returns the meta of the response, or an empty string if no header has been written
+func (w *response) Meta() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.meta
+}
+
+// BytesWritten returns the number of response body bytes written so far
+func (w *response) BytesWritten() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+// timeout answers with status code 40 if no header has been written, then closes the connection.
+// If a write is in progress, the response has already started, so the connection is closed without waiting for the
+// write, which may be blocked on a client that stopped reading.
+func (w *response) timeout() {
+	defer w.conn.Close()
+
+	if !w.mu.TryLock() {
+		return
+	}
+	defer w.mu.Unlock()
+
+	if !w.terminated {
+		_, err := w.conn.Write([]byte("40 Timeout\r\n"))
+		if err == nil {
+			w.terminated = true
+			w.status = StatusTemporaryFailure
+			w.meta = "Timeout"
+		}
+	}
 }
 
 // TitanRequest wraps a Titan request.
@@ -29,32 +187,74 @@ type TitanRequest struct {
 	Token string
 	// MIMEType contains the MIME type of the data. Defaults to "text/gemini"
 	MIMEType string
-	// Body contains the data sent by the client
-	Body []byte
+	// Size is the size in bytes of the data sent by the client
+	Size int64
+	// Body reads the data sent by the client, directly from the connection. It returns [io.EOF] after Size bytes
+	Body io.Reader
 }
 
-// Gemtext responds using a gemtext string and status code 20.
+// ReadBody reads the entire body of the [TitanRequest] into memory.
+// It returns [io.ErrUnexpectedEOF] if the client sent less data than specified by its size parameter.
+// The body is only bounded by the MaxUploadSize of the [Server] or the limit set for the route; use Body to process
+// larger uploads without buffering them.
+func (r *TitanRequest) ReadBody() ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) < r.Size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return body, nil
+}
+
+// Respond writes a success header with status code 20 and the given MIME type, and returns an [io.Writer]
+// that writes the response body to the [ResponseWriter] of the [Request]. This allows large bodies to be streamed
+// (e.g. using [io.Copy]) instead of buffering them in memory.
+// If mime is empty, "text/gemini" is used.
 // After calling this method, the [Request] has been terminated.
-func (r *Request) Gemtext(source string) error {
-	if r.terminated {
-		return errors.New("already responded")
+func (r *Request) Respond(mime string) (io.Writer, error) {
+	if mime == "" {
+		mime = "text/gemini"
+	}
+
+	err := r.writeHeader(StatusSuccess, mime)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.w, nil
+}
+
+// writeHeader writes a response header to the [ResponseWriter] of the [Request], terminating the Request
+func (r *Request) writeHeader(code int, meta string) error {
+	if r.w == nil {
+		return ErrNoResponseWriter
 	}
 
-	_, err := r.conn.Write([]byte("20 text/gemini\r\n" + source))
+	return r.w.WriteHeader(code, meta)
+}
+
+// Gemtext responds using a gemtext string and status code 20.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) Gemtext(source string) error {
+	w, err := r.Respond("text/gemini")
 	if err != nil {
 		return err
 	}
 
-	r.terminated = true
+	_, err = io.WriteString(w, source)
 
-	return nil
+	return err
 }
 
 // GemtextFile responds using gemtext from a file and status code 20.
 // After calling this method, the [Request] has been terminated.
 func (r *Request) GemtextFile(path string) error {
-	if r.terminated {
-		return errors.New("already responded")
+	if r.terminated() {
+		return ErrAlreadyResponded
 	}
 
 	source, err := os.ReadFile(path)
@@ -68,34 +268,262 @@ func (r *Request) GemtextFile(path string) error {
 // GemtextFromBuilder responds using the gemtext generated by a [gemtext.Builder] and status code 20.
 // After calling this method, the [Request] has been terminated.
 func (r *Request) GemtextFromBuilder(builder gemtext.Builder) error {
-	if r.terminated {
-		return errors.New("already responded")
+	if r.terminated() {
+		return ErrAlreadyResponded
 	}
 
 	return r.Gemtext(builder.Get())
 }
 
-// Error responds with an error code and message
+// Error responds with an error code and message. message must not exceed [MaxMetaLength] bytes.
 // After calling this method, the [Request] has been terminated.
 func (r *Request) Error(code int, message string) error {
-	_, err := r.conn.Write([]byte(fmt.Sprintf("%d %s\r\n", code, message)))
+	return r.writeHeader(code, message)
+}
 
-	return err
+// Redirect responds with a temporary redirect (status code 30) to target.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) Redirect(target string) error {
+	return r.writeHeader(StatusTemporaryRedirect, target)
+}
+
+// PermanentRedirect responds with a permanent redirect (status code 31) to target.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) PermanentRedirect(target string) error {
+	return r.writeHeader(StatusPermanentRedirect, target)
+}
+
+// TemporaryFailure responds with status code 40 and an error message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) TemporaryFailure(message string) error {
+	return r.writeHeader(StatusTemporaryFailure, message)
 }
 
-// GetClientCertificates retrieves the client certificate(s) for the [Request]
+// SlowDown responds with status code 44, asking the client to wait the given number of seconds before making
+// another request.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) SlowDown(seconds int) error {
+	return r.writeHeader(StatusSlowDown, strconv.Itoa(seconds))
+}
+
+// NotFound responds with status code 51 and an error message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) NotFound(message string) error {
+	return r.writeHeader(StatusNotFound, message)
+}
+
+// Gone responds with status code 52 and an error message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) Gone(message string) error {
+	return r.writeHeader(StatusGone, message)
+}
+
+// ProxyRequestRefused responds with status code 53 and an error message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) ProxyRequestRefused(message string) error {
+	return r.writeHeader(StatusProxyRequestRefused, message)
+}
+
+// CertificateRequired responds with status code 60 and a message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) CertificateRequired(message string) error {
+	return r.writeHeader(StatusCertificateRequired, message)
+}
+
+// CertificateNotAuthorized responds with status code 61 and a message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) CertificateNotAuthorized(message string) error {
+	return r.writeHeader(StatusCertificateNotAuthorized, message)
+}
+
+// CertificateNotValid responds with status code 62 and a message.
+// After calling this method, the [Request] has been terminated.
+func (r *Request) CertificateNotValid(message string) error {
+	return r.writeHeader(StatusCertificateNotValid, message)
+}
+
+// terminated reports whether the [Request] has been responded to
+func (r *Request) terminated() bool {
+	return r.Status() != 0
+}
+
+// responseState is implemented by a [ResponseWriter] which can report the response written to it
+type responseState interface {
+	Status() int
+	Meta() string
+	BytesWritten() int64
+}
+
+// Status returns the status code the [Request] was answered with, or 0 if it has not been responded to yet.
+// It is always 0 if the [ResponseWriter] of the Request does not report its status.
+func (r *Request) Status() int {
+	state, ok := r.w.(responseState)
+	if !ok {
+		return 0
+	}
+
+	return state.Status()
+}
+
+// Meta returns the meta the [Request] was answered with, or an empty string if it has not been responded to yet
+func (r *Request) Meta() string {
+	state, ok := r.w.(responseState)
+	if !ok {
+		return ""
+	}
+
+	return state.Meta()
+}
+
+// BytesWritten returns the number of response body bytes written so far
+func (r *Request) BytesWritten() int64 {
+	state, ok := r.w.(responseState)
+	if !ok {
+		return 0
+	}
+
+	return state.BytesWritten()
+}
+
+// Context returns the context of the [Request].
+//
+// For requests served by a [Server], the context is cancelled when the client closes the connection of a Gemini
+// request, when the server is closed or starts shutting down, or when HandlerTimeout expires, in which case its
+// deadline is set accordingly. Otherwise, it is [context.Background].
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of the [Request] with its context changed to ctx, which must not be nil.
+// It allows a [Middleware] to attach values to the context, such as the identity of the client, before passing the
+// copy on to the next [Handler].
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("gomini: nil context")
+	}
+
+	request := *r
+	request.ctx = ctx
+
+	return &request
+}
+
+// Logger returns the [slog.Logger] of the [Server] handling the [Request], annotated with the remote address of the
+// client, the host and path of the Request, and the fingerprint of the client certificate if there is one.
+// Otherwise, it returns [slog.Default].
+func (r *Request) Logger() *slog.Logger {
+	if r.logger == nil {
+		return slog.Default()
+	}
+
+	return r.logger
+}
+
+// logWriteError logs an error which occurred while writing a response
+func (r *Request) logWriteError(err error) {
+	if err != nil {
+		r.Logger().Error("Could not write response", "error", err)
+	}
+}
+
+// Path returns the path of the [Request] relative to the mount point of the [Router] handling it, as described in
+// [Router.Mount]. For a Titan request, the Titan parameters are not included. It is "/" for a request to the mount
+// point itself.
+func (r *Request) Path() string {
+	return r.path
+}
+
+// MountPoint returns the prefix the [Router] handling the [Request] is mounted under, or an empty string if the route
+// was registered directly on a [Server] or [VirtualHost]
+func (r *Request) MountPoint() string {
+	return r.mountPoint
+}
+
+// Route returns the pattern of the route handling the [Request], such as "/users/:id", including the prefixes of the
+// routers it is mounted under. It is an empty string if the Request was not routed by a [Server].
+func (r *Request) Route() string {
+	return r.route
+}
+
+// LocalAddr returns the network address of the server the [Request] was made to, or nil if it is not known
+func (r *Request) LocalAddr() net.Addr {
+	if r.conn == nil {
+		return nil
+	}
+
+	return r.conn.LocalAddr()
+}
+
+// RemoteAddr returns the network address of the client that made the [Request], or nil if it is not known
+func (r *Request) RemoteAddr() net.Addr {
+	if r.conn == nil {
+		return nil
+	}
+
+	return r.conn.RemoteAddr()
+}
+
+// ClientFingerprint returns the [Fingerprint] of the client certificate, or an empty string if the client did not
+// provide a certificate
+func (r *Request) ClientFingerprint() string {
+	certs := r.GetClientCertificates()
+	if len(certs) == 0 {
+		return ""
+	}
+
+	return Fingerprint(certs[0])
+}
+
+// Session returns the [Session] of the client, or nil if the client has no session.
+// Sessions are only available to handlers wrapped in the [Sessions] middleware.
+func (r *Request) Session() *Session {
+	return r.session
+}
+
+// ServerName returns the hostname the client requested using SNI, or an empty string if the client did not use SNI
+// or the connection is not a TLS connection
+func (r *Request) ServerName() string {
+	tlsConn, ok := r.conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	return tlsConn.ConnectionState().ServerName
+}
+
+// GetClientCertificates retrieves the client certificate(s) for the [Request].
+// Returns nil if the connection is not a TLS connection, such as when TLS is terminated by a proxy.
 func (r *Request) GetClientCertificates() []*x509.Certificate {
-	return r.conn.ConnectionState().PeerCertificates
+	tlsConn, ok := r.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	return tlsConn.ConnectionState().PeerCertificates
 }
 
 // RequestInput requests input from the user. Returns an empty string if the user has not provided input.
 func (r *Request) RequestInput(prompt string) (string, error) {
-	if r.terminated {
-		return "", errors.New("already responded")
+	return r.requestInput(StatusInput, prompt)
+}
+
+// SensitiveInput requests sensitive input (such as a password) from the user, which the client should not echo.
+// Returns an empty string if the user has not provided input.
+func (r *Request) SensitiveInput(prompt string) (string, error) {
+	return r.requestInput(StatusSensitiveInput, prompt)
+}
+
+func (r *Request) requestInput(code int, prompt string) (string, error) {
+	if r.terminated() {
+		return "", ErrAlreadyResponded
 	}
 
 	if r.URI.RawQuery == "" {
-		err := r.Error(10, prompt)
+		err := r.writeHeader(code, prompt)
 		if err != nil {
 			return "", err
 		}
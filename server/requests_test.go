@@ -0,0 +1,139 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRequest_Respond(t *testing.T) {
+	tests := map[string]struct {
+		mime string
+		want string
+	}{
+		"mime":    {mime: "text/plain; charset=utf-8", want: "20 text/plain; charset=utf-8\r\nstreamed body"},
+		"default": {mime: "", want: "20 text/gemini\r\nstreamed body"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := serveTest(t, RequestHandlerFunc(func(request Request) {
+				w, err := request.Respond(test.mime)
+				if err != nil {
+					t.Errorf("Respond failed: %v", err)
+					return
+				}
+
+				_, err = io.Copy(w, strings.NewReader("streamed body"))
+				if err != nil {
+					t.Errorf("could not write body: %v", err)
+				}
+			}), "gemini://localhost/")
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRequest_singleResponse(t *testing.T) {
+	got := serveTest(t, RequestHandlerFunc(func(request Request) {
+		err := request.Gemtext("first")
+		if err != nil {
+			t.Errorf("first response failed: %v", err)
+		}
+
+		_, err = request.Respond("text/plain")
+		if err != ErrAlreadyResponded {
+			t.Errorf("Respond returned %v, want ErrAlreadyResponded", err)
+		}
+
+		err = request.NotFound("second")
+		if err != ErrAlreadyResponded {
+			t.Errorf("NotFound returned %v, want ErrAlreadyResponded", err)
+		}
+	}), "gemini://localhost/")
+
+	want := "20 text/gemini\r\nfirst"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRequest_Error_validation(t *testing.T) {
+	tests := map[string]struct {
+		code    int
+		message string
+		err     error
+	}{
+		"meta too long":  {code: StatusNotFound, message: strings.Repeat("a", MaxMetaLength+1), err: ErrMetaTooLong},
+		"CR in meta":     {code: StatusNotFound, message: "a\rb", err: ErrInvalidMeta},
+		"LF in meta":     {code: StatusNotFound, message: "a\nb", err: ErrInvalidMeta},
+		"code too small": {code: 9, message: "Invalid", err: ErrInvalidStatus},
+		"code too large": {code: 70, message: "Invalid", err: ErrInvalidStatus},
+		"maximum length": {code: StatusNotFound, message: strings.Repeat("a", MaxMetaLength)},
+		"highest code":   {code: StatusCertificateNotValid, message: "Invalid"},
+		"lowest code":    {code: StatusInput, message: "Name?"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := serveTest(t, RequestHandlerFunc(func(request Request) {
+				err := request.Error(test.code, test.message)
+				if err != test.err {
+					t.Errorf("Error returned %v, want %v", err, test.err)
+				}
+
+				if test.err != nil && request.terminated() {
+					t.Error("invalid header terminated the request")
+				}
+			}), "gemini://localhost/")
+
+			if test.err != nil && got != "" {
+				t.Errorf("invalid header was written: %q", got)
+			}
+		})
+	}
+}
+
+func TestRequest_helpers(t *testing.T) {
+	tests := map[string]struct {
+		respond func(request Request) error
+		want    string
+	}{
+		"Redirect":                 {func(r Request) error { return r.Redirect("/new") }, "30 /new\r\n"},
+		"PermanentRedirect":        {func(r Request) error { return r.PermanentRedirect("/new") }, "31 /new\r\n"},
+		"TemporaryFailure":         {func(r Request) error { return r.TemporaryFailure("Busy") }, "40 Busy\r\n"},
+		"SlowDown":                 {func(r Request) error { return r.SlowDown(30) }, "44 30\r\n"},
+		"NotFound":                 {func(r Request) error { return r.NotFound("Missing") }, "51 Missing\r\n"},
+		"Gone":                     {func(r Request) error { return r.Gone("Deleted") }, "52 Deleted\r\n"},
+		"ProxyRequestRefused":      {func(r Request) error { return r.ProxyRequestRefused("No") }, "53 No\r\n"},
+		"CertificateRequired":      {func(r Request) error { return r.CertificateRequired("Cert") }, "60 Cert\r\n"},
+		"CertificateNotAuthorized": {func(r Request) error { return r.CertificateNotAuthorized("No") }, "61 No\r\n"},
+		"CertificateNotValid":      {func(r Request) error { return r.CertificateNotValid("Bad") }, "62 Bad\r\n"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := serveTest(t, RequestHandlerFunc(func(request Request) {
+				err := test.respond(request)
+				if err != nil {
+					t.Errorf("%s failed: %v", name, err)
+				}
+
+				if !request.terminated() {
+					t.Errorf("%s did not terminate the request", name)
+				}
+
+				if test.respond(request) != ErrAlreadyResponded {
+					t.Errorf("second call to %s did not return ErrAlreadyResponded", name)
+				}
+			}), "gemini://localhost/")
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrRouteNotFound is passed to OnError when no route matches the path of a request
+var ErrRouteNotFound = errors.New("route not found")
+
+// A Router holds a set of Gemini and Titan routes, along with middleware applied to all of them.
+//
+// Routers can be mounted under a path prefix of a [Server], [VirtualHost] or another Router with [Router.Mount],
+// allowing applications to be composed from independent modules. Routes of a mounted Router are registered relative
+// to the mount point; handlers can retrieve the path relative to the mount point with [Request.Path] and the mount
+// point itself with [Request.MountPoint].
+//
+// Route patterns consist of slash-separated segments. Besides literal segments, a segment may be:
+//
+//   - ":name", a parameter matching any non-empty segment
+//   - ":name<int>", a parameter matching a segment of decimal digits
+//   - ":name<regex>", a parameter matching a segment the regular expression matches in full. The regular expression
+//     cannot contain a slash
+//   - "*name", a catch-all parameter matching the rest of the path, including slashes, which must be the last segment
+//
+// Parameters are URL-decoded and stored in [Request.Params]. An encoded slash ("%2F") is part of a segment, rather
+// than separating segments.
+//
+// When several routes match a path, static routes are preferred over dynamic routes. Dynamic routes are compared
+// segment by segment from the left, preferring literal segments over constrained parameters, constrained parameters
+// over plain parameters, and plain parameters over catch-all parameters. Registering a route that matches exactly the
+// same paths as an existing route, or a malformed pattern, panics.
+//
+// The zero value is an empty Router ready to use.
+type Router struct {
+	staticRoutes       map[string]*routeHandler
+	staticTitanRoutes  map[string]*routeHandler
+	dynamicRoutes      []route
+	dynamicTitanRoutes []route
+	mounts             []mount
+	mu                 sync.Mutex
+	middleware         []Middleware
+	chains             map[*routeHandler]*routeHandler
+}
+
+type route struct {
+	path     string
+	segments []segment
+	handler  *routeHandler
+}
+
+// A routeHandler holds the [Handler] or [TitanHandler] of a route, possibly wrapped in middleware. Its address
+// identifies the route, so that the handler wrapped in further middleware can be cached.
+type routeHandler struct {
+	handler      Handler
+	titanHandler TitanHandler
+}
+
+type mount struct {
+	prefix string
+	router *Router
+}
+
+// segmentKind orders the kinds of path segments from most to least specific
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	constrainedSegment
+	paramSegment
+	catchAllSegment
+)
+
+// segment is one slash-separated part of a route pattern
+type segment struct {
+	kind segmentKind
+	// value is the text of a literal segment, or the name of a parameter
+	value string
+	// constraint is the source of the regular expression a constrained parameter must match
+	constraint string
+	regex      *regexp.Regexp
+}
+
+// paramTypes maps the names of built-in parameter types to the regular expressions they stand for
+var paramTypes = map[string]string{
+	"int": "[0-9]+",
+}
+
+// routeMatch describes how a path was matched by a [Router]
+type routeMatch struct {
+	// pattern is the full path the route was registered with, including the prefixes of any routers it is mounted in
+	pattern string
+	// params is nil for static routes
+	params     map[string]string
+	mountPoint string
+	path       string
+	// handler is the handler the route was registered with
+	handler *routeHandler
+	// routers are the routers the route was found in, innermost first
+	routers []*Router
+}
+
+// NewRouter creates a new, empty [Router]
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle sets up a [Handler] to handle any [Request] that comes to a path, relative to the mount point of the
+// [Router]. Any middleware passed is applied only to this route, inside the middleware registered with [Router.Use].
+func (r *Router) Handle(path string, handler Handler, middleware ...Middleware) {
+	r.register(path, &routeHandler{handler: chain(handler, middleware)})
+}
+
+// HandleFunc sets up a function to handle any [Request] that comes to a path, as with [Router.Handle]
+func (r *Router) HandleFunc(path string, handler HandlerFunc, middleware ...Middleware) {
+	r.Handle(path, handler, middleware...)
+}
+
+// RegisterHandler sets up a function to handle any [Request] that comes to a path, by calling the methods of the
+// Request, as with [Router.Handle]
+func (r *Router) RegisterHandler(path string, handler RequestHandlerFunc, middleware ...Middleware) {
+	r.Handle(path, handler, middleware...)
+}
+
+// HandleTitan sets up a [TitanHandler] to handle any [TitanRequest] that comes to a path, relative to the mount
+// point of the [Router]. Any middleware passed is applied only to this route, inside the middleware registered with
+// [Router.Use].
+func (r *Router) HandleTitan(path string, handler TitanHandler, middleware ...Middleware) {
+	r.registerTitan(path, &routeHandler{titanHandler: chainTitan(handler, middleware)})
+}
+
+// HandleTitanFunc sets up a function to handle any [TitanRequest] that comes to a path, as with [Router.HandleTitan]
+func (r *Router) HandleTitanFunc(path string, handler TitanHandlerFunc, middleware ...Middleware) {
+	r.HandleTitan(path, handler, middleware...)
+}
+
+// RegisterTitanHandler sets up a function to handle any [TitanRequest] that comes to a path, by calling the methods
+// of the TitanRequest, as with [Router.HandleTitan]
+func (r *Router) RegisterTitanHandler(path string, handler TitanRequestHandlerFunc, middleware ...Middleware) {
+	r.HandleTitan(path, handler, middleware...)
+}
+
+// Use registers middleware to be applied to every route of the [Router], including the routes of mounted routers
+// and routes registered before Use was called. It runs inside the middleware registered with [Server.Use] and the
+// middleware of any router this Router is mounted in.
+func (r *Router) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middleware = append(r.middleware, middleware...)
+	r.chains = nil
+}
+
+// wrap returns handler wrapped in the middleware of the [Router]. The wrapped handler is built the first time it is
+// needed and cached until [Router.Use] is called.
+func (r *Router) wrap(handler *routeHandler) *routeHandler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wrapped, ok := r.chains[handler]
+	if ok {
+		return wrapped
+	}
+
+	if handler.handler != nil {
+		wrapped = &routeHandler{handler: chain(handler.handler, r.middleware)}
+	} else {
+		wrapped = &routeHandler{titanHandler: chainTitan(handler.titanHandler, r.middleware)}
+	}
+
+	if r.chains == nil {
+		r.chains = make(map[*routeHandler]*routeHandler)
+	}
+	r.chains[handler] = wrapped
+
+	return wrapped
+}
+
+// Mount serves the routes of router under prefix, such as "/wiki". A request for "/wiki/page" is handled by the
+// route registered on router as "/page", and a request for "/wiki" or "/wiki/" by the route registered as "/".
+//
+// Static routes of the [Router] take precedence over mounted routers, which take precedence over its dynamic routes.
+// If several mounted routers match a path, the one with the longest prefix is tried first.
+// Mount panics if a router is already mounted under prefix.
+func (r *Router) Mount(prefix string, router *Router) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	for _, mount := range r.mounts {
+		if mount.prefix == prefix {
+			panic("gomini: a router is already mounted at " + strconv.Quote(prefix))
+		}
+	}
+
+	r.mounts = append(r.mounts, mount{prefix: prefix, router: router})
+	sort.SliceStable(r.mounts, func(i, j int) bool {
+		return len(r.mounts[i].prefix) > len(r.mounts[j].prefix)
+	})
+}
+
+// Group creates a new [Router] mounted under prefix, as with [Router.Mount], and returns it.
+// It is useful to apply middleware to a group of routes sharing a prefix.
+func (r *Router) Group(prefix string) *Router {
+	router := NewRouter()
+	r.Mount(prefix, router)
+
+	return router
+}
+
+func (r *Router) register(path string, handler *routeHandler) {
+	segments := parsePattern(path)
+	if isStatic(segments) {
+		if _, ok := r.staticRoutes[path]; ok {
+			panic(fmt.Sprintf("gomini: route %q is already registered", path))
+		}
+
+		if r.staticRoutes == nil {
+			r.staticRoutes = make(map[string]*routeHandler)
+		}
+		r.staticRoutes[path] = handler
+		return
+	}
+
+	for _, route := range r.dynamicRoutes {
+		if sameShape(route.segments, segments) {
+			panic(fmt.Sprintf("gomini: route %q conflicts with %q", path, route.path))
+		}
+	}
+
+	r.dynamicRoutes = append(r.dynamicRoutes, route{
+		path:     path,
+		segments: segments,
+		handler:  handler,
+	})
+	sort.SliceStable(r.dynamicRoutes, func(i, j int) bool {
+		return moreSpecific(r.dynamicRoutes[i].segments, r.dynamicRoutes[j].segments)
+	})
+}
+
+func (r *Router) registerTitan(path string, handler *routeHandler) {
+	segments := parsePattern(path)
+	if isStatic(segments) {
+		if _, ok := r.staticTitanRoutes[path]; ok {
+			panic(fmt.Sprintf("gomini: Titan route %q is already registered", path))
+		}
+
+		if r.staticTitanRoutes == nil {
+			r.staticTitanRoutes = make(map[string]*routeHandler)
+		}
+		r.staticTitanRoutes[path] = handler
+		return
+	}
+
+	for _, route := range r.dynamicTitanRoutes {
+		if sameShape(route.segments, segments) {
+			panic(fmt.Sprintf("gomini: Titan route %q conflicts with %q", path, route.path))
+		}
+	}
+
+	r.dynamicTitanRoutes = append(r.dynamicTitanRoutes, route{
+		path:     path,
+		segments: segments,
+		handler:  handler,
+	})
+	sort.SliceStable(r.dynamicTitanRoutes, func(i, j int) bool {
+		return moreSpecific(r.dynamicTitanRoutes[i].segments, r.dynamicTitanRoutes[j].segments)
+	})
+}
+
+// match finds the Gemini route for path, in the [Router] or any mounted routers. path is escaped, as returned by
+// [url.URL.EscapedPath], so that encoded slashes do not separate segments.
+func (r *Router) match(path string) (routeMatch, bool) {
+	return r.find(path, false)
+}
+
+// matchTitan finds the Titan route for path, in the same way as [Router.match]
+func (r *Router) matchTitan(path string) (routeMatch, bool) {
+	return r.find(path, true)
+}
+
+func (r *Router) find(path string, titan bool) (routeMatch, bool) {
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return routeMatch{}, false
+	}
+
+	staticRoutes, dynamicRoutes := r.staticRoutes, r.dynamicRoutes
+	if titan {
+		staticRoutes, dynamicRoutes = r.staticTitanRoutes, r.dynamicTitanRoutes
+	}
+
+	handler, ok := staticRoutes[decoded]
+	if ok {
+		return routeMatch{pattern: decoded, path: decoded, handler: handler, routers: []*Router{r}}, true
+	}
+
+	for _, mount := range r.mounts {
+		subPath, ok := stripMountPrefix(path, mount.prefix)
+		if !ok {
+			continue
+		}
+
+		m, ok := mount.router.find(subPath, titan)
+		if ok {
+			m.pattern = mount.prefix + m.pattern
+			m.mountPoint = mount.prefix + m.mountPoint
+			m.routers = append(m.routers, r)
+			return m, true
+		}
+	}
+
+	for _, route := range dynamicRoutes {
+		params, ok := matchSegments(route.segments, path)
+		if ok {
+			return routeMatch{
+				pattern: route.path,
+				params:  params,
+				path:    decoded,
+				handler: route.handler,
+				routers: []*Router{r},
+			}, true
+		}
+	}
+
+	return routeMatch{}, false
+}
+
+// wrapped returns the handler of the route wrapped in the middleware of the routers it was found in
+func (m routeMatch) wrapped() *routeHandler {
+	handler := m.handler
+	for _, router := range m.routers {
+		handler = router.wrap(handler)
+	}
+
+	return handler
+}
+
+// stripMountPrefix returns the escaped path relative to the mount point prefix, or false if path is not below prefix
+func stripMountPrefix(path string, prefix string) (string, bool) {
+	if prefix == "" {
+		return path, true
+	}
+
+	n := strings.Count(prefix, "/")
+	parts := strings.SplitN(path, "/", n+2)
+	if len(parts) < n+1 {
+		return "", false
+	}
+
+	decoded, err := url.PathUnescape(strings.Join(parts[:n+1], "/"))
+	if err != nil || decoded != prefix {
+		return "", false
+	}
+
+	if len(parts) == n+1 {
+		return "/", true
+	}
+
+	return "/" + parts[n+1], true
+}
+
+// parsePattern splits a route pattern into segments, panicking if it is malformed
+func parsePattern(pattern string) []segment {
+	invalid := func(reason string) {
+		panic(fmt.Sprintf("gomini: invalid route pattern %q: %s", pattern, reason))
+	}
+
+	parts := strings.Split(pattern, "/")
+	segments := make([]segment, 0, len(parts))
+	names := make(map[string]bool)
+	for i, part := range parts {
+		var seg segment
+		switch {
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				invalid("catch-all parameter must be the last segment")
+			}
+			seg = segment{kind: catchAllSegment, value: part[1:]}
+		case strings.HasPrefix(part, ":"):
+			name, constraint, constrained := strings.Cut(part[1:], "<")
+			if !constrained {
+				seg = segment{kind: paramSegment, value: name}
+				break
+			}
+
+			constraint, ok := strings.CutSuffix(constraint, ">")
+			if !ok || constraint == "" {
+				invalid("malformed constraint of parameter " + strconv.Quote(name))
+			}
+
+			expr, ok := paramTypes[constraint]
+			if !ok {
+				expr = constraint
+			}
+
+			regex, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				invalid(err.Error())
+			}
+			seg = segment{kind: constrainedSegment, value: name, constraint: expr, regex: regex}
+		default:
+			segments = append(segments, segment{kind: literalSegment, value: part})
+			continue
+		}
+
+		if seg.value == "" {
+			invalid("parameter has no name")
+		}
+		if names[seg.value] {
+			invalid("duplicate parameter " + strconv.Quote(seg.value))
+		}
+		names[seg.value] = true
+
+		segments = append(segments, seg)
+	}
+
+	return segments
+}
+
+// isStatic reports whether segments contains only literal segments
+func isStatic(segments []segment) bool {
+	for _, seg := range segments {
+		if seg.kind != literalSegment {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchSegments matches the segments of a route against an escaped path, returning the URL-decoded parameters
+func matchSegments(segments []segment, path string) (map[string]string, bool) {
+	parts := strings.Split(path, "/")
+	params := make(map[string]string)
+	for i, seg := range segments {
+		if seg.kind == catchAllSegment {
+			if i >= len(parts) {
+				return nil, false
+			}
+
+			value, err := url.PathUnescape(strings.Join(parts[i:], "/"))
+			if err != nil {
+				return nil, false
+			}
+			params[seg.value] = value
+
+			return params, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		value, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case literalSegment:
+			if value != seg.value {
+				return nil, false
+			}
+		case paramSegment:
+			if value == "" {
+				return nil, false
+			}
+			params[seg.value] = value
+		case constrainedSegment:
+			if !seg.regex.MatchString(value) {
+				return nil, false
+			}
+			params[seg.value] = value
+		}
+	}
+
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// moreSpecific reports whether the route with segments a should be tried before the route with segments b.
+// Segments are compared from left to right, with literal segments preferred over constrained parameters, constrained
+// parameters over plain parameters, and plain parameters over catch-all parameters.
+func moreSpecific(a []segment, b []segment) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].kind != b[i].kind {
+			return a[i].kind < b[i].kind
+		}
+	}
+
+	return len(a) > len(b)
+}
+
+// sameShape reports whether two routes match exactly the same paths, regardless of the names of their parameters
+func sameShape(a []segment, b []segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].kind != b[i].kind {
+			return false
+		}
+
+		switch a[i].kind {
+		case literalSegment:
+			if a[i].value != b[i].value {
+				return false
+			}
+		case constrainedSegment:
+			if a[i].constraint != b[i].constraint {
+				return false
+			}
+		}
+	}
+
+	return true
+}
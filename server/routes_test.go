@@ -0,0 +1,207 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestServer_Mount(t *testing.T) {
+	s := New()
+
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, request *Request) {
+				order = append(order, name)
+				next.ServeGemini(w, request)
+			})
+		}
+	}
+
+	describe := func(request Request) {
+		request.logWriteError(request.Gemtext(request.MountPoint() + " " + request.Path() + " " + request.Params["page"]))
+	}
+
+	s.Use(tag("server"))
+	s.RegisterHandler("/wiki-home", describe)
+
+	wiki := NewRouter()
+	wiki.Use(tag("wiki"))
+	wiki.RegisterHandler("/", describe)
+	wiki.RegisterHandler("/:page", describe)
+	s.Mount("/wiki", wiki)
+
+	admin := wiki.Group("/admin/")
+	admin.Use(tag("admin"))
+	admin.RegisterHandler("/users", describe)
+
+	tests := []struct {
+		path  string
+		want  string
+		order []string
+	}{
+		{"/wiki", "20 text/gemini\r\n/wiki / ", []string{"server", "wiki"}},
+		{"/wiki/", "20 text/gemini\r\n/wiki / ", []string{"server", "wiki"}},
+		{"/wiki/Go", "20 text/gemini\r\n/wiki /Go Go", []string{"server", "wiki"}},
+		{"/wiki/admin/users", "20 text/gemini\r\n/wiki/admin /users ", []string{"server", "wiki", "admin"}},
+		{"/wiki-home", "20 text/gemini\r\n /wiki-home ", []string{"server"}},
+	}
+
+	for _, test := range tests {
+		order = nil
+
+		handler, err := s.resolve("localhost", test.path)
+		if err != nil {
+			t.Errorf("resolve(%q) returned %v", test.path, err)
+			continue
+		}
+
+		got := serveTest(t, handler, "gemini://localhost"+test.path)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+
+		if len(order) != len(test.order) {
+			t.Errorf("%s: middleware ran as %v, want %v", test.path, order, test.order)
+			continue
+		}
+		for i := range order {
+			if order[i] != test.order[i] {
+				t.Errorf("%s: middleware ran as %v, want %v", test.path, order, test.order)
+				break
+			}
+		}
+	}
+
+	_, err := s.resolve("localhost", "/wikipedia")
+	if err != ErrRouteNotFound {
+		t.Errorf("resolve(\"/wikipedia\") returned %v, want ErrRouteNotFound", err)
+	}
+}
+
+func TestServer_MountTitan(t *testing.T) {
+	s := New()
+
+	files := s.Group("/files")
+	files.RegisterTitanHandler("/:name", func(request TitanRequest) {
+		request.logWriteError(request.Gemtext(request.MountPoint() + " " + request.Path()))
+	})
+
+	handler, err := s.titanResolve("localhost", "/files/notes.gmi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := uploadTest(t, handler, "titan://localhost/files/notes.gmi;size=2", "Hi", "text/gemini", "")
+	if got != "20 text/gemini\r\n/files /notes.gmi" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRouter_matchPatterns(t *testing.T) {
+	r := NewRouter()
+	for _, pattern := range []string{
+		"/users/:id",
+		"/users/:id<int>",
+		"/users/me",
+		"/users/:id/posts",
+		"/files/*rest",
+		"/files/:name/raw",
+		"/tags/:tag<[a-z]+>",
+		"/:identifier/:id",
+	} {
+		pattern := pattern
+		r.RegisterHandler(pattern, func(request Request) {
+			request.logWriteError(request.Gemtext(pattern))
+		})
+	}
+
+	tests := []struct {
+		path    string
+		pattern string
+		params  map[string]string
+	}{
+		{"/users/me", "/users/me", nil},
+		{"/users/42", "/users/:id<int>", map[string]string{"id": "42"}},
+		{"/users/alice", "/users/:id", map[string]string{"id": "alice"}},
+		{"/users/a%2Fb", "/users/:id", map[string]string{"id": "a/b"}},
+		{"/users/hello%20world/posts", "/users/:id/posts", map[string]string{"id": "hello world"}},
+		{"/files/a/b/c.gmi", "/files/*rest", map[string]string{"rest": "a/b/c.gmi"}},
+		{"/files/", "/files/*rest", map[string]string{"rest": ""}},
+		{"/files/a/raw", "/files/:name/raw", map[string]string{"name": "a"}},
+		{"/tags/go", "/tags/:tag<[a-z]+>", map[string]string{"tag": "go"}},
+		{"/tags/Go", "/:identifier/:id", map[string]string{"identifier": "tags", "id": "Go"}},
+		{"/users", "", nil},
+		{"/users/", "", nil},
+		{"/files", "", nil},
+	}
+
+	for _, test := range tests {
+		m, ok := r.match(test.path)
+		if !ok {
+			if test.pattern != "" {
+				t.Errorf("%s: no route matched, want %q", test.path, test.pattern)
+			}
+			continue
+		}
+
+		if m.pattern != test.pattern {
+			t.Errorf("%s: matched %q, want %q", test.path, m.pattern, test.pattern)
+			continue
+		}
+
+		if len(m.params) != len(test.params) {
+			t.Errorf("%s: got params %v, want %v", test.path, m.params, test.params)
+		}
+		for name, want := range test.params {
+			if m.params[name] != want {
+				t.Errorf("%s: got params %v, want %v", test.path, m.params, test.params)
+				break
+			}
+		}
+
+		got := serveTest(t, m.wrapped().handler, "gemini://localhost"+test.path)
+		if got != "20 text/gemini\r\n"+test.pattern {
+			t.Errorf("%s: got %q", test.path, got)
+		}
+	}
+}
+
+func TestRouter_registerConflicts(t *testing.T) {
+	handler := func(request Request) {}
+
+	tests := []struct {
+		existing string
+		pattern  string
+	}{
+		{"/about", "/about"},
+		{"/users/:id", "/users/:name"},
+		{"/users/:id<int>", "/users/:n<[0-9]+>"},
+		{"/files/*rest", "/files/*path"},
+		{"", "/files/*rest/raw"},
+		{"", "/users/:id<[0-9>"},
+		{"", "/users/:id/:id"},
+		{"", "/users/:"},
+	}
+
+	for _, test := range tests {
+		r := NewRouter()
+		if test.existing != "" {
+			r.RegisterHandler(test.existing, handler)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q after %q did not panic", test.pattern, test.existing)
+				}
+			}()
+
+			r.RegisterHandler(test.pattern, handler)
+		}()
+	}
+
+	r := NewRouter()
+	r.RegisterHandler("/users/:id", handler)
+	r.RegisterHandler("/users/:id<int>", handler)
+	r.RegisterHandler("/users/:id/posts", handler)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCGIConfig configures the handlers created by [SCGIHandler] and [SCGITitanHandler]
+type SCGIConfig struct {
+	// Network is the network of the SCGI backend, such as "tcp" or "unix". Defaults to "tcp"
+	Network string
+	// Address is the address of the SCGI backend, such as "localhost:4000" or "/run/app.sock"
+	Address string
+	// ScriptName is the URL path the backend is served at. The rest of the request path is passed to the backend as
+	// PATH_INFO. If empty, the mount point of the [Router] serving the backend is used
+	ScriptName string
+	// MaxConnections is the maximum number of concurrent connections to the backend. Requests wait for a free
+	// connection once the limit is reached. Zero means no limit
+	MaxConnections int
+	// DialTimeout is the maximum duration allowed for connecting to the backend. Defaults to 5 seconds
+	DialTimeout time.Duration
+	// Timeout is the maximum duration allowed for the backend to respond, including writing the response.
+	// Defaults to 30 seconds
+	Timeout time.Duration
+}
+
+// scgiClient forwards requests to an SCGI backend
+type scgiClient struct {
+	config SCGIConfig
+	dialer net.Dialer
+	slots  chan struct{}
+}
+
+// SCGIHandler creates a [Handler] that forwards each request to an SCGI backend, and streams the Gemini response of
+// the backend, including its status line, back to the client.
+//
+// The backend receives the same variables as a CGI script run by [CGIHandler], plus SCGI=1, CONTENT_LENGTH and a
+// PARAM_<NAME> variable for each param of a dynamic route. As SCGI backends close the connection after each response,
+// connections are not reused; instead, the handler keeps a pool of at most MaxConnections connections.
+// Requests which would place a NUL byte in a variable, such as through a percent-encoded path or param, receive
+// status code 59, as NUL bytes delimit the variables sent to the backend.
+// If the backend cannot be reached or does not write a valid status line, the client receives status code 43.
+// If the handler panics before responding, the client receives status code 42.
+func SCGIHandler(config SCGIConfig) Handler {
+	c := newSCGIClient(config)
+
+	return RequestHandlerFunc(func(request Request) {
+		c.forward(request, 0, nil, nil)
+	})
+}
+
+// SCGITitanHandler creates a [TitanHandler] that forwards each Titan request to an SCGI backend in the same way as
+// [SCGIHandler]. The uploaded data is sent as the body of the SCGI request, with its MIME type in CONTENT_TYPE and
+// the token in TITAN_TOKEN.
+func SCGITitanHandler(config SCGIConfig) TitanHandler {
+	c := newSCGIClient(config)
+
+	return TitanRequestHandlerFunc(func(request TitanRequest) {
+		c.forward(request.Request, request.Size, request.Body, []string{
+			"CONTENT_TYPE=" + request.MIMEType,
+			"TITAN_TOKEN=" + request.Token,
+		})
+	})
+}
+
+func newSCGIClient(config SCGIConfig) *scgiClient {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	c := &scgiClient{
+		config: config,
+		dialer: net.Dialer{Timeout: config.DialTimeout},
+	}
+
+	if config.MaxConnections > 0 {
+		c.slots = make(chan struct{}, config.MaxConnections)
+	}
+
+	return c
+}
+
+// dial connects to the backend, waiting for a free slot in the pool if necessary.
+// The returned function must be called to release the connection.
+func (c *scgiClient) dial(ctx context.Context) (net.Conn, func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.DialTimeout)
+	defer cancel()
+
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		if c.slots != nil {
+			<-c.slots
+		}
+	}
+
+	conn, err := c.dialer.DialContext(ctx, c.config.Network, c.config.Address)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return conn, func() {
+		conn.Close()
+		release()
+	}, nil
+}
+
+func (c *scgiClient) forward(request Request, size int64, body io.Reader, extra []string) {
+	defer cgiPanic(request)
+
+	headers := []string{"CONTENT_LENGTH=" + strconv.FormatInt(size, 10), "SCGI=1"}
+	headers = append(headers, cgiEnv(request, c.config.ScriptName)...)
+	headers = append(headers, extra...)
+	for name, value := range request.Params {
+		headers = append(headers, "PARAM_"+strings.ToUpper(name)+"="+value)
+	}
+
+	// NUL bytes separate the headers, so a value containing one could forge other headers, such as TLS_CLIENT_HASH
+	for _, header := range headers {
+		if strings.IndexByte(header, 0) >= 0 {
+			request.Logger().Warn("Refusing to forward a request containing a NUL byte to SCGI backend",
+				"backend", c.config.Address)
+			request.logWriteError(request.Error(StatusBadRequest, "Bad Request"))
+			return
+		}
+	}
+
+	conn, release, err := c.dial(request.Context())
+	if err != nil {
+		request.Logger().Error("Could not connect to SCGI backend", "backend", c.config.Address, "error", err)
+		proxyError(request)
+		return
+	}
+	defer release()
+
+	conn.SetDeadline(time.Now().Add(c.config.Timeout))
+
+	_, err = conn.Write(encodeSCGIHeaders(headers))
+	if err == nil && body != nil {
+		_, err = io.CopyN(conn, body, size)
+	}
+	if err != nil {
+		request.Logger().Error("Could not send request to SCGI backend", "backend", c.config.Address, "error", err)
+		proxyError(request)
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	code, meta, err := readCGIHeader(reader)
+	if err == nil {
+		err = request.writeHeader(code, meta)
+	}
+
+	if err != nil {
+		request.Logger().Error("SCGI backend did not write a valid header", "backend", c.config.Address, "error", err)
+		proxyError(request)
+		return
+	}
+
+	if code == StatusSuccess {
+		_, err = io.Copy(request.w, reader)
+		if err != nil {
+			request.logWriteError(err)
+		}
+	}
+}
+
+// encodeSCGIHeaders encodes headers in the form "NAME=value" as an SCGI netstring
+func encodeSCGIHeaders(headers []string) []byte {
+	var buf bytes.Buffer
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, "=")
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		buf.WriteString(value)
+		buf.WriteByte(0)
+	}
+
+	return append([]byte(strconv.Itoa(buf.Len())+":"), append(buf.Bytes(), ',')...)
+}
+
+func proxyError(request Request) {
+	if request.terminated() {
+		return
+	}
+
+	request.logWriteError(request.Error(StatusProxyError, "Proxy error"))
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveSCGI starts a fake SCGI backend, which answers every request with the result of respond
+func serveSCGI(t *testing.T, respond func(headers map[string]string, body []byte) string) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			reader := bufio.NewReader(conn)
+			length, _ := reader.ReadString(':')
+			n, _ := strconv.Atoi(strings.TrimSuffix(length, ":"))
+			netstring := make([]byte, n+1)
+			io.ReadFull(reader, netstring)
+
+			headers := make(map[string]string)
+			fields := bytes.Split(netstring[:n], []byte{0})
+			for i := 0; i+1 < len(fields); i += 2 {
+				headers[string(fields[i])] = string(fields[i+1])
+			}
+
+			contentLength, _ := strconv.Atoi(headers["CONTENT_LENGTH"])
+			body := make([]byte, contentLength)
+			io.ReadFull(reader, body)
+
+			io.WriteString(conn, respond(headers, body))
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestSCGIHandler(t *testing.T) {
+	addr := serveSCGI(t, func(headers map[string]string, body []byte) string {
+		if headers["SCGI"] != "1" || headers["CONTENT_LENGTH"] != "0" {
+			return "59 Bad SCGI request\r\n"
+		}
+
+		return "20 text/plain\r\n" + headers["PATH_INFO"] + " " + headers["QUERY_STRING"]
+	})
+
+	handler := SCGIHandler(SCGIConfig{Address: addr, ScriptName: "/app", MaxConnections: 1})
+	got := serveTest(t, handler, "gemini://localhost/app/page?search")
+	want := "20 text/plain\r\n/page search"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSCGIHandler_Unreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	got := serveTest(t, SCGIHandler(SCGIConfig{Address: addr}), "gemini://localhost/")
+	if got != "43 Proxy error\r\n" {
+		t.Errorf("got %q, want %q", got, "43 Proxy error\r\n")
+	}
+}
+
+func TestEncodeSCGIHeaders(t *testing.T) {
+	got := string(encodeSCGIHeaders([]string{"CONTENT_LENGTH=0", "SCGI=1", "EQUALS=a=b"}))
+	want := "35:CONTENT_LENGTH\x000\x00SCGI\x001\x00EQUALS\x00a=b\x00,"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSCGIHandler_NUL(t *testing.T) {
+	addr := serveSCGI(t, func(headers map[string]string, body []byte) string {
+		return "20 text/plain\r\n" + headers["TLS_CLIENT_HASH"]
+	})
+
+	s := New()
+	s.Handle("/app/:name", SCGIHandler(SCGIConfig{Address: addr, ScriptName: "/app"}))
+	serverAddr, _ := listenTest(t, s)
+
+	response, err := io.ReadAll(sendRequest(t, serverAddr, "gemini://localhost/app/x%00TLS_CLIENT_HASH%00forged%00X"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "59 Bad Request\r\n" {
+		t.Errorf("NUL in path: got %q", response)
+	}
+
+	handler := SCGIHandler(SCGIConfig{Address: addr})
+	withParam := HandlerFunc(func(w ResponseWriter, request *Request) {
+		request.Params = map[string]string{"name": "x\x00TLS_CLIENT_HASH\x00forged\x00X"}
+		handler.ServeGemini(w, request)
+	})
+	got := serveTest(t, withParam, "gemini://localhost/")
+	if got != "59 Bad Request\r\n" {
+		t.Errorf("NUL in param: got %q", got)
+	}
+}
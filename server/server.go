@@ -2,216 +2,774 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"net/url"
-	"regexp"
+	"runtime/debug"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// A Handler is a function to handle a [Request] by calling its various methods.
-// The function is called when a request that it can handle is made, as outlined in [Server.RegisterHandler]
-type Handler func(request Request)
-
 // A Server contains information required to run a TCP/TLS service capable of serving Gemini content over the internet
 type Server struct {
-	staticRoutes       map[string]Handler
-	staticTitanRoutes  map[string]TitanHandler
-	dynamicRoutes      []route
-	dynamicTitanRoutes []titanRoute
-	listener           net.Listener
-	addr               string
-	running            bool
-}
+	// HandshakeTimeout is the maximum duration allowed for the TLS handshake. Zero means no timeout
+	HandshakeTimeout time.Duration
+	// ReadHeaderTimeout is the maximum duration allowed for reading the request line. Zero means no timeout
+	ReadHeaderTimeout time.Duration
+	// AccessLog receives a line for every request once it has been answered, in AccessLogFormat. This includes
+	// requests which could not be routed or were invalid, and requests whose handler panicked. Lines are written with
+	// a single call to Write, and calls are serialised, so AccessLog need not be safe for concurrent use. To rotate log
+	// files, use a [LogFile]. If nil, no access log is written. To log the requests of some routes only, use the
+	// [AccessLog] middleware instead
+	AccessLog io.Writer
+	// AccessLogFormat is the format of the lines written to AccessLog
+	AccessLogFormat AccessLogFormat
+	// BodyReadTimeout is the maximum duration to wait for more data while reading the body of a Titan upload. The
+	// deadline is extended every time the handler reads from the body, so large uploads are not cut off as long as
+	// data keeps arriving. Zero means no timeout
+	BodyReadTimeout time.Duration
+	// WriteTimeout is the maximum duration allowed for writing the response, starting once the request line has been
+	// read. Zero means no timeout
+	WriteTimeout time.Duration
+	// HandlerTimeout is the maximum duration a handler may run for. If a handler has not responded when it expires,
+	// the client receives status code 40 and the connection is closed. Zero means no timeout
+	HandlerTimeout time.Duration
+	// MaxConnections is the maximum number of concurrently open connections. Further connections receive status code
+	// 41 until existing connections are closed. Zero means no limit
+	MaxConnections int
+	// MaxUploadSize is the maximum size in bytes of Titan uploads, unless overridden for a route with
+	// [Server.SetMaxUploadSize]. Larger uploads receive status code 59. [New] sets it to [DefaultMaxUploadSize]. Zero
+	// means no limit
+	MaxUploadSize int64
+	// Logger receives the log messages of the Server, including a record for every request with its response status,
+	// the number of bytes written and its duration, and the log messages of handlers through [Request.Logger].
+	// If nil, [slog.Default] is used
+	Logger *slog.Logger
+	// OnPanic is called when a handler panics, with the [Request] being handled, the value passed to panic and the
+	// stack trace of the handler, after the panic has been logged and the client has been answered. It can be used to
+	// forward panics to an error tracker. It must be safe to call from multiple goroutines
+	OnPanic func(request *Request, value any, stack []byte)
+	// NotFoundHandler answers Gemini and Titan requests no route matches, wrapped in the middleware registered with
+	// [Server.Use]. The body of a Titan request is not available to it. If nil, or if it does not respond, the
+	// client receives status code 51
+	NotFoundHandler Handler
+	// BadRequestHandler answers invalid requests, such as request lines which are too long or are not valid URLs, and
+	// Titan requests with missing or malformed parameters. The URI of the [Request] is empty if the request line
+	// could not be parsed. If nil, or if it does not respond, the client receives status code 59
+	BadRequestHandler Handler
+	// OnError is called for every request which cannot be passed to a route, before it is answered, with the
+	// [Request] and the reason, such as [ErrRouteNotFound], [ErrHostNotServed] or [ErrInvalidURL]. It can be used to
+	// log or count suspicious requests, and should not respond to them. It must be safe to call from multiple
+	// goroutines
+	OnError func(request *Request, err error)
 
-type route struct {
-	regex   *regexp.Regexp
-	handler Handler
+	routes            Router
+	hosts             []*VirtualHost
+	chainsMu          sync.Mutex
+	middleware        []Middleware
+	chains            map[chainKey]*routeHandler
+	notFound          Handler
+	rateLimiter       RateLimiter
+	rateLimitKey      KeyFunc
+	routeRateLimiters map[string]RateLimiter
+	maxUploadSizes    map[string]int64
+	listener          net.Listener
+	addr              net.Addr
+	inShutdown        atomic.Bool
+	mu                sync.Mutex
+	conns             map[net.Conn]connState
+	handlers          sync.WaitGroup
+	ctx               context.Context
+	cancel            context.CancelFunc
+	metrics           Metrics
+	accessLogMu       sync.Mutex
+	metricsServers    []*http.Server
 }
 
-// New creates a new [Server]
+// connState is the state of a connection tracked by a [Server]
+type connState int
+
+const (
+	// connIdle is the state of a connection which has not sent its request line yet
+	connIdle connState = iota
+	// connActive is the state of a connection whose request is being handled
+	connActive
+	// connClosed is the state of an idle connection closed by [Server.Shutdown]
+	connClosed
+)
+
+// ErrServerClosed is returned by [Server.ListenAndServe] after a call to [Server.Close] or [Server.Shutdown]
+var ErrServerClosed = errors.New("server closed")
+
+// MaxRequestLength is the maximum length in bytes of a request URL, excluding the trailing CRLF
+const MaxRequestLength = 1024
+
+var (
+	// ErrRequestTooLong is passed to OnError when a request line exceeds [MaxRequestLength] bytes
+	ErrRequestTooLong = errors.New("request line exceeds 1024 bytes")
+	// ErrInvalidURL is passed to OnError, wrapping the parsing error, when a request line is not a valid URL
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrUnsupportedScheme is passed to OnError when a request URL has a scheme other than gemini or titan
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+	// ErrInvalidTitanParameters is passed to OnError when the parameters of a Titan request are missing or malformed
+	ErrInvalidTitanParameters = errors.New("invalid Titan parameters")
+)
+
+// New creates a new [Server] with default timeouts of 10 seconds for the TLS handshake and reading the request line,
+// and 30 seconds for waiting for more data of a Titan upload, and Titan uploads limited to [DefaultMaxUploadSize] bytes
 func New() *Server {
-	return &Server{}
+	return &Server{
+		HandshakeTimeout:  10 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		BodyReadTimeout:   30 * time.Second,
+		MaxUploadSize:     DefaultMaxUploadSize,
+	}
 }
 
-// RegisterHandler sets up a [Handler] to handle any [Request] that comes to a path
-func (s *Server) RegisterHandler(path string, handler Handler) {
-	if !strings.ContainsRune(path, ':') {
-		if s.staticRoutes == nil {
-			s.staticRoutes = make(map[string]Handler)
-		}
-		s.staticRoutes[path] = handler
-	} else {
-		if s.dynamicRoutes == nil {
-			s.dynamicRoutes = make([]route, 0)
-		}
+// DefaultMaxUploadSize is the MaxUploadSize of a [Server] created with [New]
+const DefaultMaxUploadSize = 10 << 20
 
-		regex := createDynamicPathRegex(path)
+// Handle sets up a [Handler] to handle any [Request] that comes to a path.
+// Any middleware passed is applied only to this route, inside the middleware registered with [Server.Use].
+func (s *Server) Handle(path string, handler Handler, middleware ...Middleware) {
+	s.routes.Handle(path, handler, middleware...)
+}
 
-		s.dynamicRoutes = append(s.dynamicRoutes, route{
-			regex:   regexp.MustCompile(regex),
-			handler: handler,
-		})
-	}
+// HandleFunc sets up a function to handle any [Request] that comes to a path, as with [Server.Handle]
+func (s *Server) HandleFunc(path string, handler HandlerFunc, middleware ...Middleware) {
+	s.routes.Handle(path, handler, middleware...)
 }
 
-func createDynamicPathRegex(path string) string {
-	regex := "^" + path + "$"
-	parts := strings.Split(path, "/")
-	for _, part := range parts {
-		if strings.HasPrefix(part, ":") {
-			regex = strings.ReplaceAll(regex, part, fmt.Sprintf("(?P<%s>.*?)", part[1:]))
-		}
-	}
-	return regex
+// RegisterHandler sets up a function to handle any [Request] that comes to a path, by calling the methods of the
+// Request. Any middleware passed is applied only to this route, inside the middleware registered with [Server.Use].
+func (s *Server) RegisterHandler(path string, handler RequestHandlerFunc, middleware ...Middleware) {
+	s.routes.Handle(path, handler, middleware...)
+}
+
+// Mount serves the routes of router under prefix, as described in [Router.Mount]
+func (s *Server) Mount(prefix string, router *Router) {
+	s.routes.Mount(prefix, router)
 }
 
-// ListenAndServe starts the [Server] running on a specific port using the provided TLS configuration
+// Group creates a new [Router] mounted under prefix, as described in [Router.Group]
+func (s *Server) Group(prefix string) *Router {
+	return s.routes.Group(prefix)
+}
+
+// ListenAndServe starts the [Server] listening on addr using the provided TLS configuration, then calls [Server.Serve].
+// If any [VirtualHost] has a certificate and tlsConfig has no GetCertificate function, [Server.GetCertificate] is used
+// to select certificates by SNI.
+//
+// addr is a host:port pair, such as "localhost:1965" or "[::1]:1965". If the port is omitted, the default Gemini
+// port (1965) is used. A port of 0 selects a free port, which can be retrieved with [Server.Addr].
+// It always returns a non-nil error; after [Server.Close] or [Server.Shutdown], the returned error is [ErrServerClosed].
 func (s *Server) ListenAndServe(addr string, tlsConfig *tls.Config) error {
 	// TODO: don't directly use tls.Config
-	lInsecure, err := net.Listen("tcp", addr+":1965")
+	if s.inShutdown.Load() {
+		return ErrServerClosed
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	if tlsConfig.GetCertificate == nil && s.hasHostCertificates() {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.GetCertificate = s.GetCertificate
+	}
+
+	l, err := net.Listen("tcp", normalizeAddr(addr))
 	if err != nil {
 		return err
 	}
 
-	l := tls.NewListener(lInsecure, tlsConfig)
+	return s.Serve(tls.NewListener(l, tlsConfig))
+}
+
+// Serve accepts connections on l, handling each in a new goroutine.
+//
+// l should usually be created with [tls.NewListener] or [tls.Listen]. Connections which are not TLS connections are
+// served as-is, which is useful when TLS is terminated by a proxy in front of the server; client certificates are not
+// available for such connections.
+// Temporary errors accepting connections, such as running out of file descriptors, are logged and retried after a
+// delay growing up to one second. Serve returns on any other error.
+// Serve always returns a non-nil error and closes l; after [Server.Close] or [Server.Shutdown], the returned error
+// is [ErrServerClosed].
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.inShutdown.Load() {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
 	s.listener = l
-	s.addr = addr
+	s.addr = l.Addr()
+	s.mu.Unlock()
 
-	defer func() {
-		if s.running {
-			lInsecure.Close()
-			l.Close()
-		}
-	}()
+	defer l.Close()
 
-	log.Info("Listening on ", "gemini://"+addr+":1965")
-	s.running = true
-	for s.running {
+	s.logger().Info("Listening", "addr", l.Addr().String())
+	var delay time.Duration
+	for {
 		conn, err := l.Accept()
-		tlsConn, ok := conn.(*tls.Conn)
-		if !ok {
-			return errors.New("could not get tls connection")
-		}
 		if err != nil {
+			if s.inShutdown.Load() {
+				return ErrServerClosed
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay = min(2*delay, time.Second)
+				}
+				s.logger().Error("Could not accept connection, retrying", "error", err, "delay", delay)
+				time.Sleep(delay)
+				continue
+			}
 			return err
 		}
-		go s.handleConnection(tlsConn)
+		delay = 0
+
+		ok, full := s.trackConn(conn)
+		if !ok {
+			conn.Close()
+			return ErrServerClosed
+		}
+		if full {
+			s.metrics.connectionRejected()
+			go s.rejectConnection(conn)
+			continue
+		}
+		go s.handleConnection(conn)
 	}
-	return nil
 }
 
-// Close terminates the TCP server. The server will no longer accept requests after this method is called.
+// Addr returns the address the [Server] is listening on, or nil if it has not started listening yet.
+// This is useful to discover the port chosen when listening on port 0.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addr
+}
+
+// normalizeAddr adds the default Gemini port to addr if it does not include a port
+func normalizeAddr(addr string) string {
+	_, _, err := net.SplitHostPort(addr)
+	if err == nil {
+		return addr
+	}
+
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "1965")
+}
+
+// Close immediately terminates the TCP server, closing the listener and any active connections.
+// The server will no longer accept requests after this method is called. For a graceful shutdown, use [Server.Shutdown].
 func (s *Server) Close() error {
-	s.running = false
-	return s.listener.Close()
+	err := s.stopListening()
+	s.closeConns()
+
+	return err
 }
 
-func (s *Server) handleConnection(conn *tls.Conn) {
+// Shutdown gracefully shuts down the [Server]. It stops accepting new connections, closes connections which have not
+// sent their request line yet and cancels the context of in-flight requests, then waits for them to finish. If ctx
+// expires first, any remaining connections are forcibly closed and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopListening()
+	s.closeIdleConns()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// baseContext returns the context requests are derived from, which is cancelled once the server shuts down
+func (s *Server) baseContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initContext()
+
+	return s.ctx
+}
+
+// initContext creates the base context of the server if it does not exist yet. s.mu must be held
+func (s *Server) initContext() {
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// stopListening marks the server as shutting down, cancels the context of in-flight requests and closes its listener
+func (s *Server) stopListening() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inShutdown.Store(true)
+	s.initContext()
+	s.cancel()
+	for _, metricsServer := range s.metricsServers {
+		metricsServer.Close()
+	}
+	s.metricsServers = nil
+
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.Close()
+	s.listener = nil
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// trackConn registers a connection as in-flight. It returns false if the server is shutting down, and reports
+// whether the server already has MaxConnections open connections, in which case the connection is not registered.
+func (s *Server) trackConn(conn net.Conn) (ok bool, full bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown.Load() {
+		return false, false
+	}
+
+	if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+		return true, true
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = connIdle
+	s.handlers.Add(1)
+
+	return true, false
+}
+
+// rejectConnection answers a connection with status code 41 because the server has too many open connections
+func (s *Server) rejectConnection(conn net.Conn) {
 	defer conn.Close()
 
-	request := make([]byte, 1026)
-	buf := make([]byte, 1)
-	var prevByte byte = 0
-	i := 0
-	done := false
-	for !done {
-		_, err := conn.Read(buf)
+	if s.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+
+	writeStatus(s.connLogger(conn), conn, StatusServerUnavailable, "Server busy, try again later")
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.handlers.Done()
+}
+
+// closeIdleConns closes the connections which have not sent their request line yet
+func (s *Server) closeIdleConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == connIdle {
+			s.conns[conn] = connClosed
+			conn.Close()
+		}
+	}
+}
+
+// activateConn marks a connection as handling a request. It returns false if [Server.Shutdown] closed the connection
+// while it was idle, in which case the connection must be dropped without a response.
+func (s *Server) activateConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns[conn] == connClosed {
+		return false
+	}
+	s.conns[conn] = connActive
+
+	return true
+}
+
+// closedIdle reports whether [Server.Shutdown] closed a connection while it was idle
+func (s *Server) closedIdle(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns[conn] == connClosed
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.untrackConn(conn)
+	defer conn.Close()
+
+	s.metrics.connectionOpened()
+	defer s.metrics.connectionClosed()
+
+	ctx, cancel := context.WithCancel(s.baseContext())
+	defer cancel()
+
+	logger := s.connLogger(conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if s.HandshakeTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+		}
+		err := tlsConn.Handshake()
 		if err != nil {
-			log.Errorf("An error occurred while reading request %v", err)
-			_, err := conn.Write([]byte("59 Bad Request\r\n"))
-			if err != nil {
-				log.Errorf("An error occurred while writing response: %s", err.Error())
+			if s.closedIdle(conn) {
+				return
 			}
+			logger.Error("TLS handshake failed", "error", err)
+			s.metrics.handshakeFailed()
 			return
 		}
-		request[i] = buf[0]
-		i++
-		if prevByte == 13 && buf[0] == 10 {
-			done = true
+		conn.SetDeadline(time.Time{})
+
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) > 0 {
+			logger = logger.With("fingerprint", Fingerprint(certs[0]))
 		}
-		prevByte = buf[0]
 	}
-	requestUri := strings.Split(string(request), "\r\n")[0]
-	uri, err := url.Parse(requestUri)
+
+	if s.ReadHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadHeaderTimeout))
+	}
+
+	reader := bufio.NewReader(conn)
+	requestUri, err := readRequestLine(reader)
+	if !s.activateConn(conn) {
+		return
+	}
 	if err != nil {
-		log.Error("Bad URI received")
-		_, err := conn.Write([]byte("59 Bad Request\r\n"))
-		if err != nil {
-			log.Errorf("An error occurred while writing response: %s", err.Error())
+		logger.Error("Could not read request", "error", err)
+		message := "Bad Request"
+		if errors.Is(err, ErrRequestTooLong) {
+			message = "Request too long"
 		}
+		s.serveBadRequest(ctx, logger, conn, "", nil, err, message)
 		return
 	}
 
+	conn.SetReadDeadline(time.Time{})
+	if s.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+
+	uri, err := url.Parse(requestUri)
+	if err != nil {
+		logger.Error("Bad URI received", "uri", requestUri, "error", err)
+		s.serveBadRequest(ctx, logger, conn, "", nil, fmt.Errorf("%w: %w", ErrInvalidURL, err), "Bad Request")
+		return
+	}
+
+	logger = logger.With("host", uri.Host, "path", uri.Path)
 	if uri.Scheme != "gemini" && uri.Scheme != "titan" {
-		log.Error("Non-gemini or titan URI received: " + requestUri)
-		_, err := conn.Write([]byte("59 Only gemini and titan URIs are supported (for now)\r\n"))
-		if err != nil {
-			log.Errorf("An error occurred while writing response: %s", err.Error())
-		}
+		logger.Error("Non-gemini or titan URI received", "uri", requestUri)
+		s.serveBadRequest(ctx, logger, conn, "", uri, ErrUnsupportedScheme,
+			"Only gemini and titan URIs are supported (for now)")
 		return
 	}
 
 	if uri.Scheme == "gemini" {
-		s.handleGeminiRequest(conn, uri)
+		go func() {
+			// Gemini clients send nothing after the request line, so this only returns once the connection is closed
+			reader.ReadByte()
+			cancel()
+		}()
+
+		s.handleGeminiRequest(ctx, logger, conn, uri)
 	} else {
-		s.handleTitanRequest(conn, uri)
+		s.handleTitanRequest(ctx, logger, conn, reader, uri)
 	}
 }
 
-func (s *Server) handleGeminiRequest(conn *tls.Conn, uri *url.URL) {
-	handler, err := s.resolve(uri.Path)
+// logger returns the [slog.Logger] of the server
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+
+	return s.Logger
+}
+
+// connLogger returns the [slog.Logger] of the server, annotated with the remote address of conn
+func (s *Server) connLogger(conn net.Conn) *slog.Logger {
+	return s.logger().With("remote_addr", conn.RemoteAddr().String())
+}
+
+// writeStatus writes a response header directly to conn, for responses sent before a [Request] has been created
+func writeStatus(logger *slog.Logger, conn net.Conn, code int, meta string) {
+	_, err := fmt.Fprintf(conn, "%d %s\r\n", code, meta)
 	if err != nil {
-		log.Error(uri.Path + " not found")
-		_, err := conn.Write([]byte("51 Not Found\r\n"))
+		logger.Error("Could not write response", "error", err)
+	}
+}
+
+// readRequestLine reads a CRLF terminated request line of at most [MaxRequestLength] bytes, excluding the CRLF
+func readRequestLine(reader *bufio.Reader) (string, error) {
+	line := make([]byte, 0, 64)
+	for {
+		b, err := reader.ReadByte()
 		if err != nil {
-			log.Errorf("An error occurred while writing response: %s", err.Error())
+			return "", err
+		}
+
+		line = append(line, b)
+		if len(line) >= 2 && line[len(line)-2] == '\r' && b == '\n' {
+			return string(line[:len(line)-2]), nil
+		}
+
+		if len(line) > MaxRequestLength+1 {
+			return "", ErrRequestTooLong
+		}
+	}
+}
+
+// serveResolveError answers a request for uri using protocol which could not be routed, either with status code 53
+// if the server does not serve the requested host, or with NotFoundHandler
+func (s *Server) serveResolveError(ctx context.Context, logger *slog.Logger, conn net.Conn, protocol string,
+	uri *url.URL, err error) {
+	if errors.Is(err, ErrHostNotServed) {
+		logger.Warn("Host not served")
+		s.serveError(ctx, logger, conn, protocol, uri, nil, err, StatusProxyRequestRefused, "Proxy request refused")
+		return
+	}
+
+	logger.Warn("Route not found")
+	var handler Handler
+	if s.NotFoundHandler != nil {
+		handler = HandlerFunc(func(w ResponseWriter, request *Request) {
+			s.notFoundHandler().ServeGemini(w, request)
+		})
+	}
+	s.serveError(ctx, logger, conn, protocol, uri, handler, err, StatusNotFound, "Not Found")
+}
+
+// serveBadRequest answers an invalid request with BadRequestHandler, or with status code 59 and meta.
+// uri is nil if the request line could not be parsed.
+func (s *Server) serveBadRequest(ctx context.Context, logger *slog.Logger, conn net.Conn, protocol string,
+	uri *url.URL, err error, meta string) {
+	s.serveError(ctx, logger, conn, protocol, uri, s.BadRequestHandler, err, StatusBadRequest, meta)
+}
+
+// serveError answers a request which cannot be passed to a route because of err. OnError is called first, then
+// handler if it is not nil. If the request has not been responded to afterwards, the client receives code and meta.
+func (s *Server) serveError(ctx context.Context, logger *slog.Logger, conn net.Conn, protocol string, uri *url.URL,
+	handler Handler, err error, code int, meta string) {
+	var u url.URL
+	if uri != nil {
+		u = *uri
+	}
+
+	request := newRequest(u, conn)
+	defer s.completeRequest(logger, protocol, &request, handler != nil, time.Now())
+
+	s.runHandler(ctx, logger, &request, func() {
+		if s.OnError != nil {
+			s.OnError(&request, err)
 		}
+
+		if handler != nil {
+			handler.ServeGemini(request.w, &request)
+		}
+	})
+
+	if !request.terminated() {
+		request.logWriteError(request.writeHeader(code, meta))
+	}
+}
+
+// runHandler calls handle after setting the context of request to ctx and its logger to logger, enforcing
+// HandlerTimeout and recovering from panics
+func (s *Server) runHandler(ctx context.Context, logger *slog.Logger, request *Request, handle func()) {
+	if s.HandlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.HandlerTimeout)
+		defer cancel()
+
+		timedOut := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			defer close(timedOut)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				request.w.(*response).timeout()
+			}
+		})
+		defer func() {
+			// Wait for the timeout response to be written before the connection is closed
+			if !stop() {
+				<-timedOut
+			}
+		}()
+	}
+
+	request.ctx = ctx
+	request.logger = logger
+	defer s.recoverHandler(request)
+	handle()
+}
+
+// recoverHandler recovers from a panic in the handler of request. The panic is logged along with a stack trace, and
+// if the handler has not responded yet, the client receives status code 40. The connection is then closed as usual.
+func (s *Server) recoverHandler(request *Request) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	request.Logger().Error("Handler panicked", "panic", value, "stack", string(stack))
+	if !request.terminated() {
+		request.logWriteError(request.TemporaryFailure("Temporary failure"))
+	}
+
+	if s.OnPanic != nil {
+		s.OnPanic(request, value, stack)
+	}
+}
+
+func (s *Server) handleGeminiRequest(ctx context.Context, logger *slog.Logger, conn net.Conn, uri *url.URL) {
+	handler, err := s.resolve(uri.Hostname(), uri.EscapedPath())
+	if err != nil {
+		s.serveResolveError(ctx, logger, conn, "gemini", uri, err)
 		return
 	}
 
-	handler(Request{
-		URI:  *uri,
-		conn: conn,
+	request := newRequest(*uri, conn)
+	defer s.completeRequest(logger, "gemini", &request, true, time.Now())
+
+	s.runHandler(ctx, logger, &request, func() {
+		handler.ServeGemini(request.w, &request)
 	})
+}
+
+// completeRequest records a request using protocol once it has been answered, logging it along with its response
+// status, the number of bytes written and the time since start, and updating the metrics and the access log. handled
+// reports whether the request was passed to a handler.
+func (s *Server) completeRequest(logger *slog.Logger, protocol string, request *Request, handled bool,
+	start time.Time) {
+	duration := time.Since(start)
+	logger.Info("Request completed",
+		"status", request.Status(),
+		"bytes", request.BytesWritten(),
+		"duration", duration,
+	)
+
+	if handled {
+		s.metrics.observeHandled(protocol, request, duration)
+	} else {
+		s.metrics.observeRequest(protocol, request.Status())
+	}
+
+	s.writeAccessLog(request, start, duration)
+}
+
+func (s *Server) resolve(host string, path string) (Handler, error) {
+	table, err := s.routeTable(host)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := table.match(path)
+	if !ok {
+		return nil, ErrRouteNotFound
+	}
+
+	return HandlerFunc(func(w ResponseWriter, request *Request) {
+		request.Params = m.params
+		request.path = m.path
+		request.mountPoint = m.mountPoint
+		request.route = m.pattern
+		s.routeChain(m).handler.ServeGemini(w, request)
+	}), nil
+}
 
-	log.Info("Gemini request received for " + strings.TrimRight(uri.String(), "\r\n"))
+// chainKey identifies a route wrapped in the middleware of the routers it was found in, along with its pattern
+type chainKey struct {
+	handler *routeHandler
+	pattern string
 }
 
-func (s *Server) resolve(path string) (Handler, error) {
-	handler, ok := s.staticRoutes[path]
+// routeChain returns the handler of the route m matched, wrapped in the middleware of the routers it was found in,
+// its rate limiter, its upload size limit and the middleware of the [Server]. The handler is built the first time it
+// is needed and cached, so it is only built when a request is being handled, where panics in middleware are
+// recovered.
+func (s *Server) routeChain(m routeMatch) *routeHandler {
+	handler := m.wrapped()
+
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
+
+	key := chainKey{handler: handler, pattern: m.pattern}
+	wrapped, ok := s.chains[key]
 	if ok {
-		return handler, nil
+		return wrapped
 	}
 
-	for _, route := range s.dynamicRoutes {
-		if route.regex.MatchString(path) {
-			params := extractParams(path, route.regex)
+	if handler.handler != nil {
+		wrapped = &routeHandler{handler: chain(chain(handler.handler, s.rateLimit(m.pattern)), s.middleware)}
+	} else {
+		titanHandler := chainTitan(s.limitUpload(m.pattern, handler.titanHandler), s.rateLimit(m.pattern))
+		wrapped = &routeHandler{titanHandler: chainTitan(titanHandler, s.middleware)}
+	}
 
-			return func(request Request) {
-				request.Params = params
-				route.handler(request)
-			}, nil
-		}
+	if s.chains == nil {
+		s.chains = make(map[chainKey]*routeHandler)
 	}
+	s.chains[key] = wrapped
 
-	return nil, errors.New("route not found")
+	return wrapped
 }
 
-func extractParams(path string, regex *regexp.Regexp) map[string]string {
-	submatches := regex.FindStringSubmatch(path)
-	params := make(map[string]string)
-	for i, submatch := range submatches {
-		if i == 0 {
-			continue
-		}
+// notFoundHandler returns NotFoundHandler wrapped in the middleware of the [Server], building it the first time it is
+// needed
+func (s *Server) notFoundHandler() Handler {
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
 
-		name := regex.SubexpNames()[i]
-		params[name] = submatch
+	if s.notFound == nil {
+		s.notFound = chain(s.NotFoundHandler, s.middleware)
 	}
-	return params
+
+	return s.notFound
+}
+
+// resetChains discards the cached handlers built by [Server.routeChain] and [Server.notFoundHandler], so that they
+// are built again with the current configuration. chainsMu must be held.
+func (s *Server) resetChains() {
+	s.chains = nil
+	s.notFound = nil
 }
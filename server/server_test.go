@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenTest serves s on a free local TCP port without TLS, returning the address it is listening on and a channel
+// receiving the error returned by [Server.Serve]
+func listenTest(t *testing.T, s *Server) (string, <-chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return l.Addr().String(), served
+}
+
+// sendRequest opens a connection to addr and sends a request line for uri
+func sendRequest(t *testing.T, addr string, uri string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	_, err = io.WriteString(conn, uri+"\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestServer_Shutdown_drains(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s := New()
+	s.RegisterHandler("/", func(request Request) {
+		close(started)
+		<-release
+		request.Gemtext("done")
+	})
+
+	addr, served := listenTest(t, s)
+	conn := sendRequest(t, addr, "gemini://localhost/")
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the handler finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "20 text/gemini\r\ndone" {
+		t.Errorf("got response %q", response)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServer_Shutdown_deadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	s := New()
+	s.RegisterHandler("/", func(request Request) {
+		close(started)
+		<-release
+	})
+
+	addr, _ := listenTest(t, s)
+	conn := sendRequest(t, addr, "gemini://localhost/")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown returned %v, want context.DeadlineExceeded", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection was not closed: %v", err)
+	}
+	if len(response) != 0 {
+		t.Errorf("got response %q", response)
+	}
+}
+
+func TestServer_Shutdown_idle(t *testing.T) {
+	s := New()
+	s.ReadHeaderTimeout = 0
+	s.OnError = func(request *Request, err error) {
+		t.Errorf("OnError called with %v", err)
+	}
+
+	addr, _ := listenTest(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for the connection to be tracked
+	deadline := time.Now().Add(time.Second)
+	for s.Metrics().Snapshot().ActiveConnections == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err = s.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown took %v with an idle connection", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = bufio.NewReader(conn).ReadByte()
+	if err != io.EOF {
+		t.Errorf("read from idle connection returned %v, want io.EOF", err)
+	}
+}
+
+func TestServer_BodyReadTimeout(t *testing.T) {
+	s := New()
+	s.BodyReadTimeout = 100 * time.Millisecond
+	s.RegisterTitanHandler("/upload", func(request TitanRequest) {
+		_, err := request.ReadBody()
+		if err == nil {
+			t.Error("ReadBody succeeded without a body")
+		}
+		request.TemporaryFailure("Upload timed out")
+	})
+
+	addr, _ := listenTest(t, s)
+	conn := sendRequest(t, addr, "titan://localhost/upload;size=1000000")
+	_, err := io.WriteString(conn, "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("upload was not cut off: %v", err)
+	}
+
+	if string(response) != "40 Upload timed out\r\n" {
+		t.Errorf("got response %q", response)
+	}
+}
+
+func TestServer_HandlerTimeout_blockedWrite(t *testing.T) {
+	returned := make(chan error, 1)
+
+	s := New()
+	s.HandlerTimeout = 200 * time.Millisecond
+	s.HandleFunc("/", func(w ResponseWriter, request *Request) {
+		chunk := make([]byte, 1<<20)
+		for i := 0; i < 64; i++ {
+			_, err := w.Write(chunk)
+			if err != nil {
+				returned <- err
+				return
+			}
+		}
+		returned <- nil
+	})
+
+	addr, _ := listenTest(t, s)
+	sendRequest(t, addr, "gemini://localhost/") // The client never reads the response
+
+	select {
+	case err := <-returned:
+		if err == nil {
+			t.Error("expected the write to fail once the handler timed out")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler still blocked writing after HandlerTimeout")
+	}
+}
+
+// temporaryError is a [net.Error] reporting itself as temporary, like EMFILE
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails to accept the first failures connections with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, temporaryError{}
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestServer_Serve_temporaryError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyListener{Listener: l}
+	flaky.failures.Store(3)
+
+	s := New()
+	s.RegisterHandler("/", func(request Request) {
+		request.Gemtext("ok")
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(flaky)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	response, err := io.ReadAll(sendRequest(t, l.Addr().String(), "gemini://localhost/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "20 text/gemini\r\nok" {
+		t.Errorf("got %q", response)
+	}
+
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v after temporary errors", err)
+	default:
+	}
+}
+
+func TestServer_MaxUploadSize_default(t *testing.T) {
+	s := New()
+	s.RegisterTitanHandler("/upload", func(request TitanRequest) {
+		request.ReadBody()
+		request.Gemtext("stored")
+	})
+
+	addr, _ := listenTest(t, s)
+	uri := "titan://localhost/upload;size=" + strconv.Itoa(DefaultMaxUploadSize+1)
+	response, err := io.ReadAll(sendRequest(t, addr, uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "59 Upload exceeds " + strconv.Itoa(DefaultMaxUploadSize) + " bytes\r\n"
+	if string(response) != want {
+		t.Errorf("got %q, want %q", response, want)
+	}
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Session holds data associated with a client certificate across requests.
+// Sessions are attached to requests by the [Sessions] middleware, and retrieved with [Request.Session].
+type Session struct {
+	// ID is the [Fingerprint] of the client certificate the session belongs to
+	ID string
+	// Expires is the time the session expires, unless the client makes another request before then
+	Expires time.Time
+	mu      sync.Mutex
+	values  map[string]string
+}
+
+// sessionData is the serialised form of a [Session]
+type sessionData struct {
+	ID      string            `json:"id"`
+	Expires time.Time         `json:"expires"`
+	Values  map[string]string `json:"values"`
+}
+
+// NewSession creates an empty [Session] for the client certificate with fingerprint id
+func NewSession(id string, expires time.Time) *Session {
+	return &Session{
+		ID:      id,
+		Expires: expires,
+		values:  make(map[string]string),
+	}
+}
+
+// Get retrieves a value from the [Session], or an empty string if it is not set
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.values[key]
+}
+
+// Set stores a value in the [Session]
+func (s *Session) Set(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+}
+
+// Delete removes a value from the [Session]
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+}
+
+// Expired reports whether the [Session] has expired
+func (s *Session) Expired() bool {
+	return time.Now().After(s.Expires)
+}
+
+func (s *Session) data() sessionData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string]string, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+
+	return sessionData{ID: s.ID, Expires: s.Expires, Values: values}
+}
+
+func (d sessionData) session() *Session {
+	session := NewSession(d.ID, d.Expires)
+	for key, value := range d.Values {
+		session.values[key] = value
+	}
+
+	return session
+}
+
+// A SessionStore persists sessions between requests.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load retrieves the session with an ID, returning nil if it does not exist or has expired
+	Load(id string) (*Session, error)
+	// Save stores a session, replacing any existing session with the same ID
+	Save(session *Session) error
+	// Delete removes the session with an ID, if it exists
+	Delete(id string) error
+}
+
+// MemorySessionStore is a [SessionStore] which keeps sessions in memory. Sessions are lost when the process exits.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]sessionData
+	lastSweep time.Time
+}
+
+// NewMemorySessionStore creates an empty [MemorySessionStore]
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]sessionData),
+	}
+}
+
+// Load implements [SessionStore]
+func (m *MemorySessionStore) Load(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if time.Now().After(data.Expires) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+
+	return data.session(), nil
+}
+
+// Save implements [SessionStore]
+func (m *MemorySessionStore) Save(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.ID] = session.data()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= time.Minute {
+		m.lastSweep = now
+		for id, data := range m.sessions {
+			if now.After(data.Expires) {
+				delete(m.sessions, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete implements [SessionStore]
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+// FileSessionStore is a [SessionStore] which keeps each session in a JSON file within a directory
+type FileSessionStore struct {
+	dir string
+}
+
+var errInvalidSessionID = errors.New("invalid session ID")
+
+// NewFileSessionStore creates a [FileSessionStore] keeping sessions in dir, creating the directory if necessary
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path returns the path of the file for a session. IDs must be hex encoded, so that they cannot escape the directory.
+func (f *FileSessionStore) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", errInvalidSessionID
+	}
+
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+// Load implements [SessionStore]
+func (f *FileSessionStore) Load(id string) (*Session, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data sessionData
+	err = json.Unmarshal(contents, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(data.Expires) {
+		return nil, f.Delete(id)
+	}
+
+	return data.session(), nil
+}
+
+// Save implements [SessionStore]. Sessions are written atomically, so a failed save does not corrupt the session.
+func (f *FileSessionStore) Save(session *Session) error {
+	path, err := f.path(session.ID)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(session.data())
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, bytes.NewReader(contents), -1, 0o600)
+}
+
+// Delete implements [SessionStore]
+func (f *FileSessionStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// SessionConfig configures the [Sessions] middleware
+type SessionConfig struct {
+	// Store persists sessions. Defaults to a new [MemorySessionStore]
+	Store SessionStore
+	// Lifetime is how long a session lasts after the last request made with it. Defaults to 24 hours
+	Lifetime time.Duration
+	// Required makes clients without a certificate receive status code 60, rather than being handled without a session
+	Required bool
+}
+
+// Sessions creates a [Middleware] that attaches a [Session] to every request made with a client certificate, which
+// can be retrieved with [Request.Session]. Sessions are identified by the [Fingerprint] of the certificate, and saved
+// to the store once the handler returns.
+//
+// Requests made with a certificate which has expired or is not yet valid receive status code 62.
+func Sessions(config SessionConfig) Middleware {
+	if config.Store == nil {
+		config.Store = NewMemorySessionStore()
+	}
+
+	if config.Lifetime == 0 {
+		config.Lifetime = 24 * time.Hour
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, request *Request) {
+			certs := request.GetClientCertificates()
+			if len(certs) == 0 {
+				if config.Required {
+					request.logWriteError(request.CertificateRequired("Certificate required"))
+					return
+				}
+
+				next.ServeGemini(w, request)
+				return
+			}
+
+			now := time.Now()
+			if now.Before(certs[0].NotBefore) || now.After(certs[0].NotAfter) {
+				request.logWriteError(request.CertificateNotValid("Certificate expired or not yet valid"))
+				return
+			}
+
+			id := Fingerprint(certs[0])
+			session, err := config.Store.Load(id)
+			if err != nil {
+				request.Logger().Error("Could not load session", "session", id, "error", err)
+				request.logWriteError(request.TemporaryFailure("Could not load session"))
+				return
+			}
+
+			if session == nil {
+				session = NewSession(id, now)
+			}
+			session.Expires = now.Add(config.Lifetime)
+
+			request.session = session
+			next.ServeGemini(w, request)
+
+			err = config.Store.Save(session)
+			if err != nil {
+				request.Logger().Error("Could not save session", "session", id, "error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	t.Helper()
+
+	session := NewSession("abcdef", time.Now().Add(time.Hour))
+	session.Set("name", "alice")
+
+	err := store.Save(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load("abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded == nil || loaded.Get("name") != "alice" {
+		t.Fatalf("session was not loaded")
+	}
+
+	expired := NewSession("012345", time.Now().Add(-time.Second))
+	err = store.Save(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = store.Load("012345")
+	if err != nil || loaded != nil {
+		t.Errorf("expired session was loaded")
+	}
+
+	err = store.Delete("abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = store.Load("abcdef")
+	if err != nil || loaded != nil {
+		t.Errorf("deleted session was loaded")
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore())
+}
+
+func TestFileSessionStore(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSessionStore(t, store)
+
+	_, err = store.Load("../../etc/passwd")
+	if err == nil {
+		t.Errorf("no error for session ID outside store directory")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store := NewMemorySessionStore()
+	handler := chain(RequestHandlerFunc(func(request Request) {
+		session := request.Session()
+		count := session.Get("count") + "I"
+		session.Set("count", count)
+
+		request.logWriteError(request.Gemtext(count))
+	}), []Middleware{Sessions(SessionConfig{Store: store, Required: true})})
+
+	got := serveTest(t, handler, "gemini://localhost/")
+	if got != "60 Certificate required\r\n" {
+		t.Errorf("without certificate: got %q", got)
+	}
+
+	cert := newTestCertificate(t, "alice", false, nil)
+	for _, want := range []string{"20 text/gemini\r\nI", "20 text/gemini\r\nII"} {
+		got := serveTLSTest(t, handler, "gemini://localhost/", cert)
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	expired := signTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bob"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+	}, nil)
+
+	got = serveTLSTest(t, handler, "gemini://localhost/", expired)
+	if got != "62 Certificate expired or not yet valid\r\n" {
+		t.Errorf("with expired certificate: got %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+// Status codes defined by the Gemini specification.
+// These can be passed to [Request.Error], although the dedicated helper methods on [Request] should be preferred.
+const (
+	// StatusInput requests a line of input from the user. The meta is a prompt
+	StatusInput = 10
+	// StatusSensitiveInput requests sensitive input (e.g. a password) from the user. The meta is a prompt
+	StatusSensitiveInput = 11
+
+	// StatusSuccess marks a successful response. The meta is the MIME type of the body
+	StatusSuccess = 20
+
+	// StatusTemporaryRedirect redirects the client to a new URL. The meta is the new URL
+	StatusTemporaryRedirect = 30
+	// StatusPermanentRedirect redirects the client to a new URL, which should be used for all future requests
+	StatusPermanentRedirect = 31
+
+	// StatusTemporaryFailure marks a failure which may not occur if the request is repeated
+	StatusTemporaryFailure = 40
+	// StatusServerUnavailable marks the server as unavailable due to overload or maintenance
+	StatusServerUnavailable = 41
+	// StatusCGIError marks a failure in a CGI process
+	StatusCGIError = 42
+	// StatusProxyError marks a failure of a proxy to complete a request
+	StatusProxyError = 43
+	// StatusSlowDown asks the client to wait before making another request. The meta is the number of seconds to wait
+	StatusSlowDown = 44
+
+	// StatusPermanentFailure marks a failure which will occur again if the request is repeated
+	StatusPermanentFailure = 50
+	// StatusNotFound marks that the requested resource could not be found
+	StatusNotFound = 51
+	// StatusGone marks that the requested resource is no longer available, and will not be again
+	StatusGone = 52
+	// StatusProxyRequestRefused marks a request for a resource at a domain not served by the server
+	StatusProxyRequestRefused = 53
+	// StatusBadRequest marks a request which could not be parsed
+	StatusBadRequest = 59
+
+	// StatusCertificateRequired marks that a client certificate is required to access the resource
+	StatusCertificateRequired = 60
+	// StatusCertificateNotAuthorized marks that the supplied client certificate is not authorised to access the resource
+	StatusCertificateNotAuthorized = 61
+	// StatusCertificateNotValid marks that the supplied client certificate is not valid
+	StatusCertificateNotValid = 62
+)
+
+// MaxMetaLength is the maximum length in bytes of the meta field of a response header
+const MaxMetaLength = 1024
+
+// StatusText returns a short description of a status code, or an empty string if the code is unknown
+func StatusText(code int) string {
+	switch code {
+	case StatusInput:
+		return "Input"
+	case StatusSensitiveInput:
+		return "Sensitive input"
+	case StatusSuccess:
+		return "Success"
+	case StatusTemporaryRedirect:
+		return "Temporary redirect"
+	case StatusPermanentRedirect:
+		return "Permanent redirect"
+	case StatusTemporaryFailure:
+		return "Temporary failure"
+	case StatusServerUnavailable:
+		return "Server unavailable"
+	case StatusCGIError:
+		return "CGI error"
+	case StatusProxyError:
+		return "Proxy error"
+	case StatusSlowDown:
+		return "Slow down"
+	case StatusPermanentFailure:
+		return "Permanent failure"
+	case StatusNotFound:
+		return "Not found"
+	case StatusGone:
+		return "Gone"
+	case StatusProxyRequestRefused:
+		return "Proxy request refused"
+	case StatusBadRequest:
+		return "Bad request"
+	case StatusCertificateRequired:
+		return "Certificate required"
+	case StatusCertificateNotAuthorized:
+		return "Certificate not authorised"
+	case StatusCertificateNotValid:
+		return "Certificate not valid"
+	default:
+		return ""
+	}
+}
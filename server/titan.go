@@ -1,56 +1,120 @@
 package server
 
 import (
-	"crypto/tls"
-	"errors"
-	log "github.com/sirupsen/logrus"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// A TitanHandler is a function to handle a [TitanRequest] by calling its various methods.
-// The function is called when a titan request that it can handle is made as outlined in [Server.RegisterTitanHandler]
-type TitanHandler func(request TitanRequest)
+// A TitanHandler responds to a [TitanRequest], in the same way as a [Handler] responds to a Gemini request.
+type TitanHandler interface {
+	ServeTitan(w ResponseWriter, r *TitanRequest)
+}
+
+// TitanHandlerFunc adapts an ordinary function to a [TitanHandler]
+type TitanHandlerFunc func(w ResponseWriter, r *TitanRequest)
+
+// ServeTitan calls f(w, r). If r has no [ResponseWriter], f receives a copy of r whose response methods write to w,
+// as with [HandlerFunc].
+func (f TitanHandlerFunc) ServeTitan(w ResponseWriter, r *TitanRequest) {
+	if r.w == nil {
+		request := *r
+		request.w = w
+		r = &request
+	}
 
-type titanRoute struct {
-	regex   *regexp.Regexp
-	handler TitanHandler
+	f(w, r)
 }
 
-// RegisterTitanHandler sets up a [TitanHandler] to handle any [TitanRequest] that comes to a path
-func (s *Server) RegisterTitanHandler(path string, handler TitanHandler) {
-	if !strings.ContainsRune(path, ':') {
-		if s.staticTitanRoutes == nil {
-			s.staticTitanRoutes = make(map[string]TitanHandler)
-		}
-		s.staticTitanRoutes[path] = handler
-	} else {
-		if s.dynamicTitanRoutes == nil {
-			s.dynamicTitanRoutes = make([]titanRoute, 0)
-		}
+// TitanRequestHandlerFunc adapts a function responding using the methods of a [TitanRequest], as registered with
+// [Server.RegisterTitanHandler], to a [TitanHandler]
+type TitanRequestHandlerFunc func(request TitanRequest)
 
-		regex := createDynamicPathRegex(path)
+// ServeTitan calls f with a copy of r which responds to w
+func (f TitanRequestHandlerFunc) ServeTitan(w ResponseWriter, r *TitanRequest) {
+	request := *r
+	request.w = w
+	f(request)
+}
 
-		s.dynamicTitanRoutes = append(s.dynamicTitanRoutes, titanRoute{
-			regex:   regexp.MustCompile(regex),
-			handler: handler,
-		})
+// bindTitanWriter wraps handler in the same way as bindWriter
+func bindTitanWriter(handler TitanHandler) TitanHandler {
+	return TitanHandlerFunc(func(w ResponseWriter, r *TitanRequest) {
+		request := *r
+		request.w = w
+		handler.ServeTitan(w, &request)
+	})
+}
+
+// HandleTitan sets up a [TitanHandler] to handle any [TitanRequest] that comes to a path.
+// Any middleware passed is applied only to this route, inside the middleware registered with [Server.Use].
+func (s *Server) HandleTitan(path string, handler TitanHandler, middleware ...Middleware) {
+	s.routes.HandleTitan(path, handler, middleware...)
+}
+
+// HandleTitanFunc sets up a function to handle any [TitanRequest] that comes to a path, as with [Server.HandleTitan]
+func (s *Server) HandleTitanFunc(path string, handler TitanHandlerFunc, middleware ...Middleware) {
+	s.routes.HandleTitan(path, handler, middleware...)
+}
+
+// RegisterTitanHandler sets up a function to handle any [TitanRequest] that comes to a path, by calling the methods
+// of the TitanRequest. Any middleware passed is applied only to this route, inside the middleware registered with
+// [Server.Use].
+func (s *Server) RegisterTitanHandler(path string, handler TitanRequestHandlerFunc, middleware ...Middleware) {
+	s.routes.HandleTitan(path, handler, middleware...)
+}
+
+// SetMaxUploadSize limits the size in bytes of uploads to a Titan route, as passed to [Server.HandleTitan].
+// This overrides MaxUploadSize for the route. Uploads exceeding the limit receive status code 59 before their body is
+// read. A limit of zero or less removes the limit for the route.
+func (s *Server) SetMaxUploadSize(path string, size int64) {
+	s.chainsMu.Lock()
+	defer s.chainsMu.Unlock()
+
+	s.resetChains()
+	if s.maxUploadSizes == nil {
+		s.maxUploadSizes = make(map[string]int64)
+	}
+	s.maxUploadSizes[path] = size
+}
+
+// limitUpload wraps handler to refuse uploads exceeding the size limit of the route registered at path
+func (s *Server) limitUpload(path string, handler TitanHandler) TitanHandler {
+	limit, ok := s.maxUploadSizes[path]
+	if !ok {
+		limit = s.MaxUploadSize
+	}
+
+	if limit <= 0 {
+		return handler
 	}
+
+	return TitanHandlerFunc(func(w ResponseWriter, request *TitanRequest) {
+		if request.Size > limit {
+			request.logWriteError(w.WriteHeader(StatusBadRequest, "Upload exceeds "+strconv.FormatInt(limit, 10)+" bytes"))
+			return
+		}
+
+		handler.ServeTitan(w, request)
+	})
 }
 
-func (s *Server) handleTitanRequest(conn *tls.Conn, uri *url.URL) {
+func (s *Server) handleTitanRequest(ctx context.Context, logger *slog.Logger, conn net.Conn, reader io.Reader,
+	uri *url.URL) {
 	rawParameters := strings.Split(uri.Path, ";")[1:]
 	parameters := make(map[string]string)
 	for _, rawParameter := range rawParameters {
 		parameter := strings.Split(rawParameter, "=")
 		if len(parameter) != 2 {
-			log.Error("Malformed Parameter: " + rawParameter)
-			_, err := conn.Write([]byte("59 Malformed parameter\r\n"))
-			if err != nil {
-				log.Errorf("An error occurred while writing response: %s", err.Error())
-			}
+			logger.Error("Malformed parameter", "parameter", rawParameter)
+			err := fmt.Errorf("%w: malformed parameter %q", ErrInvalidTitanParameters, rawParameter)
+			s.serveBadRequest(ctx, logger, conn, "titan", uri, err, "Malformed parameter")
 			return
 		}
 		parameters[parameter[0]] = parameter[1]
@@ -73,64 +137,73 @@ func (s *Server) handleTitanRequest(conn *tls.Conn, uri *url.URL) {
 
 	size, ok := parameters["size"]
 	if !ok {
-		log.Error("Missing size parameter")
-		_, err := conn.Write([]byte("59 Missing size parameter\r\n"))
-		if err != nil {
-			log.Errorf("An error occurred while writing response: %s", err.Error())
-		}
+		logger.Error("Missing size parameter")
+		err := fmt.Errorf("%w: missing size parameter", ErrInvalidTitanParameters)
+		s.serveBadRequest(ctx, logger, conn, "titan", uri, err, "Missing size parameter")
 		return
 	} else {
-		sizeInt, err := strconv.Atoi(size)
-		if err != nil {
-			log.Error("Malformed size parameter: " + size)
-			_, err := conn.Write([]byte("59 Size must be a number\r\n"))
-			if err != nil {
-				log.Errorf("An error occurred while writing response: %s", err.Error())
-			}
-			return
-		}
-		body := make([]byte, sizeInt)
-		_, err = conn.Read(body)
-		if err != nil {
-			log.Errorf("An error occurred while reading request body: %s", err.Error())
+		sizeInt, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || sizeInt < 0 {
+			logger.Error("Malformed size parameter", "size", size)
+			err := fmt.Errorf("%w: malformed size parameter %q", ErrInvalidTitanParameters, size)
+			s.serveBadRequest(ctx, logger, conn, "titan", uri, err, "Size must be a number")
 			return
 		}
 
-		titanRequest.Body = body
+		titanRequest.Size = sizeInt
+		body := reader
+		if s.BodyReadTimeout > 0 {
+			body = &deadlineReader{reader: reader, conn: conn, timeout: s.BodyReadTimeout}
+		}
+		titanRequest.Body = io.LimitReader(body, sizeInt)
 
-		handler, err := s.titanResolve(strings.Split(uri.Path, ";")[0])
+		path, _, _ := strings.Cut(uri.EscapedPath(), ";")
+		handler, err := s.titanResolve(uri.Hostname(), path)
 		if err != nil {
-			log.Error(uri.Path + " not found")
-			_, err := conn.Write([]byte("51 Not Found\r\n"))
-			if err != nil {
-				log.Errorf("An error occurred while writing response: %s", err.Error())
-			}
-
+			s.serveResolveError(ctx, logger, conn, "titan", uri, err)
 			return
 		}
 
-		titanRequest.conn = conn
-		handler(titanRequest)
-		log.Infof("Titan request received for %s", strings.TrimRight(uri.String(), "\r\n"))
+		titanRequest.Request = newRequest(*uri, conn)
+		s.metrics.observeUpload(sizeInt)
+		completionLogger := logger.With("size", sizeInt, "mime", titanRequest.MIMEType)
+		defer s.completeRequest(completionLogger, "titan", &titanRequest.Request, true, time.Now())
+
+		s.runHandler(ctx, logger, &titanRequest.Request, func() {
+			handler.ServeTitan(titanRequest.w, &titanRequest)
+		})
 	}
 }
 
-func (s *Server) titanResolve(path string) (TitanHandler, error) {
-	handler, ok := s.staticTitanRoutes[path]
-	if ok {
-		return handler, nil
-	}
+// deadlineReader reads from a connection, extending its read deadline by timeout before every read
+type deadlineReader struct {
+	reader  io.Reader
+	conn    net.Conn
+	timeout time.Duration
+}
 
-	for _, route := range s.dynamicTitanRoutes {
-		if route.regex.MatchString(path) {
-			params := extractParams(path, route.regex)
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
 
-			return func(request TitanRequest) {
-				request.Params = params
-				route.handler(request)
-			}, nil
-		}
+	return r.reader.Read(p)
+}
+
+func (s *Server) titanResolve(host string, path string) (TitanHandler, error) {
+	table, err := s.routeTable(host)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := table.matchTitan(path)
+	if !ok {
+		return nil, ErrRouteNotFound
 	}
 
-	return nil, errors.New("route not found")
+	return TitanHandlerFunc(func(w ResponseWriter, request *TitanRequest) {
+		request.Params = m.params
+		request.path = m.path
+		request.mountPoint = m.mountPoint
+		request.route = m.pattern
+		s.routeChain(m).titanHandler.ServeTitan(w, request)
+	}), nil
 }
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+)
+
+// ErrHostNotServed is passed to OnError when a request is for a host the [Server] does not serve
+var ErrHostNotServed = errors.New("host not served")
+
+// A VirtualHost serves requests for one or more hostnames with its own routes, and optionally its own certificate.
+//
+// Virtual hosts are created with [Server.Host]. Once any virtual host has been created, requests for hostnames that
+// do not match a virtual host are answered with status code 53, and routes registered directly on the [Server] are
+// no longer used.
+//
+// Routes are registered on the embedded [Router], so routers can also be mounted on a VirtualHost, and middleware
+// registered with [Router.Use] applies only to the routes of the VirtualHost.
+type VirtualHost struct {
+	Router
+	pattern     string
+	certificate *tls.Certificate
+}
+
+// Host returns the [VirtualHost] serving hostnames matching pattern, creating it if it does not exist.
+//
+// pattern is either an exact hostname such as "example.org", a wildcard matching any subdomain such as
+// "*.example.org", or "*" to match any hostname. Exact patterns take precedence over wildcards, and longer wildcards
+// take precedence over shorter ones.
+func (s *Server) Host(pattern string) *VirtualHost {
+	pattern = normalizeHostname(pattern)
+	for _, host := range s.hosts {
+		if host.pattern == pattern {
+			return host
+		}
+	}
+
+	host := &VirtualHost{pattern: pattern}
+	s.hosts = append(s.hosts, host)
+
+	return host
+}
+
+// SetCertificate sets the certificate presented to clients requesting the [VirtualHost] using SNI
+func (h *VirtualHost) SetCertificate(certificate tls.Certificate) {
+	h.certificate = &certificate
+}
+
+// GetCertificate selects the certificate of the [VirtualHost] matching the SNI server name of a TLS handshake.
+// It is suitable for use as [tls.Config.GetCertificate]. If no virtual host with a certificate matches, it returns
+// nil, so that the certificates of the [tls.Config] are used instead.
+func (s *Server) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := s.lookupHost(hello.ServerName)
+	if host == nil {
+		return nil, nil
+	}
+
+	return host.certificate, nil
+}
+
+func (s *Server) hasHostCertificates() bool {
+	for _, host := range s.hosts {
+		if host.certificate != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routeTable returns the routes serving hostname, or ErrHostNotServed if the server does not serve it
+func (s *Server) routeTable(hostname string) (*Router, error) {
+	if len(s.hosts) == 0 {
+		return &s.routes, nil
+	}
+
+	host := s.lookupHost(hostname)
+	if host == nil {
+		return nil, ErrHostNotServed
+	}
+
+	return &host.Router, nil
+}
+
+// lookupHost finds the most specific [VirtualHost] matching hostname, or nil if none match
+func (s *Server) lookupHost(hostname string) *VirtualHost {
+	hostname = normalizeHostname(hostname)
+
+	var best *VirtualHost
+	for _, host := range s.hosts {
+		if host.pattern == hostname {
+			return host
+		}
+
+		if matchHost(host.pattern, hostname) && (best == nil || len(host.pattern) > len(best.pattern)) {
+			best = host
+		}
+	}
+
+	return best
+}
+
+// matchHost reports whether a wildcard host pattern matches hostname
+func matchHost(pattern string, hostname string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	suffix, ok := strings.CutPrefix(pattern, "*")
+	if !ok || !strings.HasPrefix(suffix, ".") {
+		return false
+	}
+
+	return len(hostname) > len(suffix) && strings.HasSuffix(hostname, suffix)
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
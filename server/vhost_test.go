@@ -0,0 +1,39 @@
+package server
+
+import "testing"
+
+func TestServer_lookupHost(t *testing.T) {
+	s := New()
+	exact := s.Host("example.org")
+	wildcard := s.Host("*.example.org")
+	deeper := s.Host("*.wiki.example.org")
+
+	tests := []struct {
+		hostname string
+		want     *VirtualHost
+	}{
+		{"example.org", exact},
+		{"EXAMPLE.org.", exact},
+		{"www.example.org", wildcard},
+		{"en.wiki.example.org", deeper},
+		{"wiki.example.org", wildcard},
+		{"example.com", nil},
+		{"badexample.org", nil},
+	}
+
+	for _, test := range tests {
+		got := s.lookupHost(test.hostname)
+		if got != test.want {
+			t.Errorf("lookupHost(%q) returned the wrong host", test.hostname)
+		}
+	}
+
+	catchAll := s.Host("*")
+	if s.lookupHost("example.com") != catchAll {
+		t.Errorf("lookupHost did not fall back to the catch-all host")
+	}
+
+	if s.Host("example.org") != exact {
+		t.Errorf("Host created a duplicate virtual host")
+	}
+}